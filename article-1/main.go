@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// getCharacter returns the character at index in str. The index counts
+// runes, not bytes, so multibyte characters such as 'é' are returned whole.
+// Negative indices count from the end, so -1 is the last character.
+// The index is checked up front instead of recovering from a panic.
+//
+// It is no longer a thin wrapper over At([]rune(str), index): that
+// conversion allocated on every call, so getCharacter walks str in place
+// instead, with the same indexing and errors as At.
+func getCharacter(str string, index int) (rune, error) {
+	if !IsValidIndex(str, index) {
+		return 0, errOutOfRange(index)
+	}
+
+	// Pure ASCII strings have one byte per rune, so they can be indexed
+	// directly without decoding.
+	if isASCII(str) {
+		pos, _ := normalizeIndex(index, len(str))
+		return rune(str[pos]), nil
+	}
+
+	// Otherwise walk the runes in place rather than converting str to a
+	// []rune, which would allocate on every call.
+	char, _, err := CharacterAt(str, index)
+	return char, err
+}
+
+// GetCharacterOr is getCharacter for callers that prefer a default value to
+// an error: it returns fallback when index is out of range.
+func GetCharacterOr(str string, index int, fallback rune) rune {
+	char, err := getCharacter(str, index)
+	if err != nil {
+		return fallback
+	}
+	return char
+}
+
+// IsValidIndex reports whether getCharacter would find a character at index
+// in str, counting runes and allowing negative indices.
+func IsValidIndex(str string, index int) bool {
+	_, ok := normalizeIndex(index, utf8.RuneCountInString(str))
+	return ok
+}
+
+// isASCII reports whether every byte of str is an ASCII character.
+func isASCII(str string) bool {
+	for i := 0; i < len(str); i++ {
+		if str[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeIndex maps a possibly negative index onto [0, length) and reports
+// whether it is in range.
+func normalizeIndex(index, length int) (int, bool) {
+	if index < 0 {
+		index += length
+	}
+	return index, index >= 0 && index < length
+}
+
+// errOutOfRange is the error returned for an index outside the string.
+func errOutOfRange(index int) error {
+	return fmt.Errorf("attempted to access index %d out of range", index)
+}
+
+func main() {
+	word := "Héllo"
+	fmt.Printf("'%s' has %d bytes but %d characters\n", word, ByteCount(word), CharCount(word))
+
+	char, err := getCharacter(word, 1)
+	if err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Printf("this is '%c' char\n", char)
+	}
+
+	if last, err := getCharacter(word, -1); err == nil {
+		fmt.Printf("the last char is '%c'\n", last)
+	}
+
+	if _, err := getCharacter(word, 30); err != nil {
+		fmt.Println(err)
+	}
+}
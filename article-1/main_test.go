@@ -0,0 +1,1025 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestGetCharacter(t *testing.T) {
+	tests := []struct {
+		str   string
+		index int
+		want  rune
+	}{
+		{"Hello", 1, 'e'},
+		{"Héllo", 1, 'é'},
+		{"Héllo", 2, 'l'},
+		{"naïve", 2, 'ï'},
+		{"hi 👋 there", 3, '👋'},
+		{"hi 👋 there", 4, ' '},
+		{"🍕🍔", 1, '🍔'},
+	}
+	for _, tt := range tests {
+		got, err := getCharacter(tt.str, tt.index)
+		if err != nil || got != tt.want {
+			t.Errorf("getCharacter(%q, %d) = %q, %v, want %q", tt.str, tt.index, got, err, tt.want)
+		}
+	}
+}
+
+func TestGetCharacterOutOfRange(t *testing.T) {
+	tests := []struct {
+		str   string
+		index int
+	}{
+		{"Héllo", 5}, // 6 bytes but only 5 runes
+		{"👋", 1},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		_, err := getCharacter(tt.str, tt.index)
+		want := fmt.Sprintf("attempted to access index %d out of range", tt.index)
+		if err == nil || err.Error() != want {
+			t.Errorf("getCharacter(%q, %d) error = %v, want %q", tt.str, tt.index, err, want)
+		}
+	}
+}
+
+// getCharacterRecover is getCharacter as it was before the bounds check,
+// recovering from the panic of an out-of-range index.
+func getCharacterRecover(str string, index int) (char rune, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("attempted to access index %d out of range", index)
+		}
+	}()
+
+	char = []rune(str)[index]
+	return char, nil
+}
+
+func TestGetCharacterDoesNotAllocate(t *testing.T) {
+	for _, str := range []string{"Hello, world", "Héllo, wörld"} {
+		allocs := testing.AllocsPerRun(100, func() { getCharacter(str, 8) })
+		if allocs != 0 {
+			t.Errorf("getCharacter(%q, 8) made %v allocations, want 0", str, allocs)
+		}
+	}
+}
+
+func BenchmarkGetCharacter(b *testing.B) {
+	impls := []struct {
+		name string
+		fn   func(string, int) (rune, error)
+	}{
+		{"recover", getCharacterRecover},
+		{"check", getCharacter},
+	}
+	inputs := []struct {
+		name  string
+		str   string
+		index int
+	}{
+		{"ASCII", "Hello, world", 8},
+		{"multibyte", "Héllo, wörld", 8},
+		{"out of range", "Héllo, wörld", 30},
+	}
+	for _, impl := range impls {
+		for _, in := range inputs {
+			b.Run(impl.name+"/"+in.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for b.Loop() {
+					impl.fn(in.str, in.index)
+				}
+			})
+		}
+	}
+}
+
+func TestGetCharacterNegativeIndex(t *testing.T) {
+	tests := []struct {
+		index int
+		want  rune
+	}{
+		{-1, 'e'},
+		{-2, 'v'},
+		{-3, 'ï'},
+		{-5, 'n'}, // -len
+	}
+	for _, tt := range tests {
+		got, err := getCharacter("naïve", tt.index)
+		if err != nil || got != tt.want {
+			t.Errorf("getCharacter(%q, %d) = %q, %v, want %q", "naïve", tt.index, got, err, tt.want)
+		}
+	}
+
+	// -(len+1) is one past the start.
+	if _, err := getCharacter("naïve", -6); err == nil || err.Error() != "attempted to access index -6 out of range" {
+		t.Errorf("getCharacter(%q, -6) error = %v, want out of range", "naïve", err)
+	}
+}
+
+func TestSubstring(t *testing.T) {
+	tests := []struct {
+		str        string
+		start, end int
+		want       string
+	}{
+		{"héllo wörld", 1, 5, "éllo"},
+		{"héllo wörld", 6, 11, "wörld"},
+		{"héllo wörld", -5, -2, "wör"},
+		{"héllo", 2, 2, ""},
+		{"héllo", 0, 5, "héllo"},
+		{"👋🌍", 1, 2, "🌍"},
+	}
+	for _, tt := range tests {
+		got, err := Substring(tt.str, tt.start, tt.end)
+		if err != nil || got != tt.want {
+			t.Errorf("Substring(%q, %d, %d) = %q, %v, want %q", tt.str, tt.start, tt.end, got, err, tt.want)
+		}
+	}
+}
+
+func TestSubstringErrors(t *testing.T) {
+	tests := []struct {
+		str        string
+		start, end int
+		want       string
+	}{
+		{"héllo", 3, 1, "invalid range [3, 1): start is after end"},
+		{"héllo", -1, -3, "invalid range [-1, -3): start is after end"},
+		{"héllo", 0, 6, "attempted to access index 6 out of range"},
+		{"héllo", -6, 2, "attempted to access index -6 out of range"},
+	}
+	for _, tt := range tests {
+		_, err := Substring(tt.str, tt.start, tt.end)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("Substring(%q, %d, %d) error = %v, want %q", tt.str, tt.start, tt.end, err, tt.want)
+		}
+	}
+}
+
+func TestReverseString(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"hello", "olleh"},
+		{"héllo", "olléh"},
+		{"hi 👋", "👋 ih"},
+		{"", ""},
+		// A decomposed é keeps its combining acute accent after the e.
+		{"cafe\u0301!", "!e\u0301fac"},
+	}
+	for _, tt := range tests {
+		got := ReverseString(tt.in)
+		if got != tt.want {
+			t.Errorf("ReverseString(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("ReverseString(%q) = %q, which is not valid UTF-8", tt.in, got)
+		}
+	}
+}
+
+func TestAt(t *testing.T) {
+	ints := []int{10, 20, 30}
+	if got, err := At(ints, 1); err != nil || got != 20 {
+		t.Errorf("At(%v, 1) = %d, %v, want 20", ints, got, err)
+	}
+	if got, err := At(ints, -1); err != nil || got != 30 {
+		t.Errorf("At(%v, -1) = %d, %v, want 30", ints, got, err)
+	}
+	if _, err := At(ints, 3); err == nil {
+		t.Errorf("At(%v, 3) succeeded, want out of range", ints)
+	}
+
+	words := []string{"olá", "mundo"}
+	if got, err := At(words, 0); err != nil || got != "olá" {
+		t.Errorf("At(%q, 0) = %q, %v, want %q", words, got, err, "olá")
+	}
+	if got, err := At(words, -3); err == nil || got != "" {
+		t.Errorf("At(%q, -3) = %q, %v, want the zero value and an error", words, got, err)
+	}
+}
+
+func TestAtEmpty(t *testing.T) {
+	got, err := At([]int{}, 0)
+	if err == nil || err.Error() != "attempted to access index 0 out of range" {
+		t.Errorf("At([]int{}, 0) error = %v, want out of range", err)
+	}
+	if got != 0 {
+		t.Errorf("At([]int{}, 0) = %d, want the zero value", got)
+	}
+	if _, err := At[string](nil, -1); err == nil {
+		t.Error("At(nil, -1) succeeded, want out of range")
+	}
+}
+
+func TestByteAndCharCount(t *testing.T) {
+	tests := []struct {
+		s            string
+		bytes, runes int
+	}{
+		{"hello", 5, 5},
+		{"héllo", 6, 5},
+		{"👋", 4, 1},
+		{"", 0, 0},
+		// Each invalid byte counts as one replacement character.
+		{"a\xff\xfeb", 4, 4},
+		{"\xe2\x82", 2, 2}, // truncated '€'
+	}
+	for _, tt := range tests {
+		if got := ByteCount(tt.s); got != tt.bytes {
+			t.Errorf("ByteCount(%q) = %d, want %d", tt.s, got, tt.bytes)
+		}
+		if got := CharCount(tt.s); got != tt.runes {
+			t.Errorf("CharCount(%q) = %d, want %d", tt.s, got, tt.runes)
+		}
+	}
+}
+
+func TestCharacterAt(t *testing.T) {
+	tests := []struct {
+		str    string
+		index  int
+		char   rune
+		offset int
+	}{
+		{"aé c", 2, ' ', 3}, // é takes two bytes
+		{"aé c", 1, 'é', 1},
+		{"aé c", -1, 'c', 4},
+		{"👋x", 1, 'x', 4},
+	}
+	for _, tt := range tests {
+		char, offset, err := CharacterAt(tt.str, tt.index)
+		if err != nil || char != tt.char || offset != tt.offset {
+			t.Errorf("CharacterAt(%q, %d) = %q, %d, %v, want %q, %d", tt.str, tt.index, char, offset, err, tt.char, tt.offset)
+		}
+		if err == nil && tt.str[offset:][:utf8.RuneLen(char)] != string(char) {
+			t.Errorf("slicing %q at %d does not start with %q", tt.str, offset, char)
+		}
+	}
+	if _, _, err := CharacterAt("aé c", 4); err == nil {
+		t.Error("CharacterAt past the end succeeded, want out of range")
+	}
+}
+
+func TestIsValidIndex(t *testing.T) {
+	tests := []struct {
+		str   string
+		index int
+		want  bool
+	}{
+		{"hello", 0, true},
+		{"hello", 4, true},
+		{"hello", -5, true},
+		{"hello", 5, false},
+		{"hello", -6, false},
+		{"héllo", 4, true}, // 6 bytes, 5 runes
+		{"héllo", 5, false},
+		{"héllo", -5, true},
+		{"héllo", -6, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		if got := IsValidIndex(tt.str, tt.index); got != tt.want {
+			t.Errorf("IsValidIndex(%q, %d) = %t, want %t", tt.str, tt.index, got, tt.want)
+		}
+		if _, err := getCharacter(tt.str, tt.index); (err == nil) != tt.want {
+			t.Errorf("getCharacter(%q, %d) error = %v, disagreeing with IsValidIndex", tt.str, tt.index, err)
+		}
+	}
+}
+
+func TestFindAll(t *testing.T) {
+	tests := []struct {
+		str    string
+		target rune
+		want   []int
+	}{
+		{"hello", 'z', []int{}},
+		{"banana", 'a', []int{1, 3, 5}},
+		{"été déjà", 'é', []int{0, 2, 5}},
+		// 'é' is C3 A9 and 'ã' is C3 A3: a shared lead byte is no match.
+		{"ãã", 'é', []int{}},
+	}
+	for _, tt := range tests {
+		got := FindAll(tt.str, tt.target)
+		if got == nil || !slices.Equal(got, tt.want) {
+			t.Errorf("FindAll(%q, %q) = %#v, want %v", tt.str, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestGetCharacterOr(t *testing.T) {
+	tests := []struct {
+		str   string
+		index int
+		want  rune
+	}{
+		{"héllo", 1, 'é'},
+		{"héllo", -1, 'o'},
+		{"héllo", 5, '?'},
+		{"héllo", -6, '?'},
+		{"", 0, '?'},
+	}
+	for _, tt := range tests {
+		if got := GetCharacterOr(tt.str, tt.index, '?'); got != tt.want {
+			t.Errorf("GetCharacterOr(%q, %d, '?') = %q, want %q", tt.str, tt.index, got, tt.want)
+		}
+	}
+}
+
+func TestWordAt(t *testing.T) {
+	tests := []struct {
+		str   string
+		index int
+		want  string
+	}{
+		{"hello big world", 0, "hello"},
+		{"hello big world", 7, "big"},
+		{"hello big world", -1, "world"},
+		{"hello big world", 5, ""}, // a space
+		{"olá são paulo", 5, "são"},
+		{"olá\tmundo", 3, ""},
+	}
+	for _, tt := range tests {
+		got, err := WordAt(tt.str, tt.index)
+		if err != nil || got != tt.want {
+			t.Errorf("WordAt(%q, %d) = %q, %v, want %q", tt.str, tt.index, got, err, tt.want)
+		}
+	}
+
+	_, err := WordAt("olá", 3)
+	if err == nil || err.Error() != "attempted to access index 3 out of range" {
+		t.Errorf("WordAt(%q, 3) error = %v, want getCharacter's out of range error", "olá", err)
+	}
+}
+
+func TestGetCharacterUTF16(t *testing.T) {
+	const str = "a😀b" // 😀 is a surrogate pair in UTF-16
+	tests := []struct {
+		index int
+		want  rune
+	}{
+		{0, 'a'},
+		{1, '😀'},
+		{2, '😀'},
+		{3, 'b'},
+	}
+	for _, tt := range tests {
+		got, err := GetCharacterUTF16(str, tt.index)
+		if err != nil || got != tt.want {
+			t.Errorf("GetCharacterUTF16(%q, %d) = %q, %v, want %q", str, tt.index, got, err, tt.want)
+		}
+	}
+
+	// Starting with the emoji, index 2 is past it.
+	if got, err := GetCharacterUTF16("😀é", 2); err != nil || got != 'é' {
+		t.Errorf("GetCharacterUTF16(%q, 2) = %q, %v, want 'é'", "😀é", got, err)
+	}
+	for _, index := range []int{4, -1} {
+		if _, err := GetCharacterUTF16(str, index); err == nil {
+			t.Errorf("GetCharacterUTF16(%q, %d) succeeded, want out of range", str, index)
+		}
+	}
+}
+
+// getCharacterRunes is the plain rune-aware lookup that the ASCII fast path
+// of getCharacter must agree with.
+func getCharacterRunes(str string, index int) (rune, error) {
+	return At([]rune(str), index)
+}
+
+func TestGetCharacterFastPathMatchesRunes(t *testing.T) {
+	inputs := []string{"", "a", "Hello, world", "Héllo", "naïve café", "hi 👋", "\xffab", "ab\xc3"}
+	for _, str := range inputs {
+		n := utf8.RuneCountInString(str)
+		for index := -n - 2; index <= n+1; index++ {
+			got, gotErr := getCharacter(str, index)
+			want, wantErr := getCharacterRunes(str, index)
+			if got != want || fmt.Sprint(gotErr) != fmt.Sprint(wantErr) {
+				t.Errorf("getCharacter(%q, %d) = %q, %v, want %q, %v", str, index, got, gotErr, want, wantErr)
+			}
+		}
+	}
+}
+
+func BenchmarkGetCharacterInput(b *testing.B) {
+	impls := []struct {
+		name string
+		fn   func(string, int) (rune, error)
+	}{
+		{"runes", getCharacterRunes},
+		{"getCharacter", getCharacter},
+	}
+	inputs := []struct {
+		name string
+		str  string
+	}{
+		{"ASCII", strings.Repeat("hello world ", 50)},
+		{"multibyte", strings.Repeat("héllo wörld ", 50)},
+	}
+	for _, impl := range impls {
+		for _, in := range inputs {
+			b.Run(impl.name+"/"+in.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for b.Loop() {
+					impl.fn(in.str, 300)
+				}
+			})
+		}
+	}
+}
+
+func TestTrimRunes(t *testing.T) {
+	tests := []struct {
+		s, cutset, want string
+	}{
+		{"--hello--", "-", "hello"},
+		{"xyhelloyx", "xy", "hello"},
+		{"…wait…", "…", "wait"},
+		// '…' is E2 80 A6 and '€' is E2 82 AC: sharing a byte is no match.
+		{"€5€", "…", "€5€"},
+		{"¡¿olé?!", "¡¿?!", "olé"},
+		{"  hello  ", "", "  hello  "},
+	}
+	for _, tt := range tests {
+		if got := TrimRunes(tt.s, tt.cutset); got != tt.want {
+			t.Errorf("TrimRunes(%q, %q) = %q, want %q", tt.s, tt.cutset, got, tt.want)
+		}
+	}
+}
+
+func TestEachCharacter(t *testing.T) {
+	var indices []int
+	var chars []rune
+	EachCharacter("héllo👋", func(index int, char rune) bool {
+		indices = append(indices, index)
+		chars = append(chars, char)
+		return true
+	})
+	if !slices.Equal(indices, []int{0, 1, 2, 3, 4, 5}) || string(chars) != "héllo👋" {
+		t.Errorf("EachCharacter visited %v %q, want rune indices 0 to 5 of %q", indices, string(chars), "héllo👋")
+	}
+
+	var last int
+	EachCharacter("héllo", func(index int, char rune) bool {
+		last = index
+		return char != 'l'
+	})
+	if last != 2 {
+		t.Errorf("EachCharacter stopped at index %d, want 2", last)
+	}
+}
+
+func TestPad(t *testing.T) {
+	tests := []struct {
+		s           string
+		width       int
+		pad         rune
+		left, right string
+	}{
+		{"é", 3, '*', "**é", "é**"},
+		{"ab", 4, '·', "··ab", "ab··"},
+		{"héllo", 3, '*', "héllo", "héllo"}, // already wider
+		{"héllo", 5, '*', "héllo", "héllo"},
+		{"", 2, '👋', "👋👋", "👋👋"},
+	}
+	for _, tt := range tests {
+		if got := PadLeft(tt.s, tt.width, tt.pad); got != tt.left {
+			t.Errorf("PadLeft(%q, %d, %q) = %q, want %q", tt.s, tt.width, tt.pad, got, tt.left)
+		}
+		if got := PadRight(tt.s, tt.width, tt.pad); got != tt.right {
+			t.Errorf("PadRight(%q, %d, %q) = %q, want %q", tt.s, tt.width, tt.pad, got, tt.right)
+		}
+	}
+}
+
+func TestCaesarShift(t *testing.T) {
+	tests := []struct {
+		s     string
+		shift int
+		want  string
+	}{
+		{"abc", 1, "bcd"},
+		{"xyz", 3, "abc"}, // wraps around at z
+		{"Hello, World!", 13, "Uryyb, Jbeyq!"},
+		{"abc", 27, "bcd"},  // 27 is 1 modulo 26
+		{"abc", -27, "zab"}, // and so is -27 in the other direction
+		{"café 123", 1, "dbgé 123"},
+	}
+	for _, tt := range tests {
+		got := CaesarShift(tt.s, tt.shift)
+		if got != tt.want {
+			t.Errorf("CaesarShift(%q, %d) = %q, want %q", tt.s, tt.shift, got, tt.want)
+		}
+		if back := CaesarShift(got, -tt.shift); back != tt.s {
+			t.Errorf("CaesarShift(%q, %d) = %q, want the original %q", got, -tt.shift, back, tt.s)
+		}
+	}
+}
+
+func TestDecodeFirstRune(t *testing.T) {
+	tests := []struct {
+		s     string
+		r     rune
+		size  int
+		valid bool
+	}{
+		{"abc", 'a', 1, true},
+		{"éa", 'é', 2, true},
+		{"👋!", '👋', 4, true},
+		{"\xffa", utf8.RuneError, 1, false},
+		{"\xe2\x82", utf8.RuneError, 1, false}, // truncated '€'
+		{"", utf8.RuneError, 0, false},
+		{"�", utf8.RuneError, 3, true}, // a real replacement character
+	}
+	for _, tt := range tests {
+		r, size, valid := DecodeFirstRune(tt.s)
+		if r != tt.r || size != tt.size || valid != tt.valid {
+			t.Errorf("DecodeFirstRune(%q) = %q, %d, %t, want %q, %d, %t", tt.s, r, size, valid, tt.r, tt.size, tt.valid)
+		}
+	}
+}
+
+func TestToTitleCase(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"hello big WORLD", "Hello Big World"},
+		{"  leading space", "  Leading Space"},
+		{"école ÉTÉ", "École Été"},
+		{"ıstanbul", "Istanbul"}, // dotless ı takes two bytes, I takes one
+		{"tab\tand\nnewline", "Tab\tAnd\nNewline"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := ToTitleCase(tt.in); got != tt.want {
+			t.Errorf("ToTitleCase(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSplitRunes(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"abc", []string{"a", "b", "c"}},
+		{"héy", []string{"h", "é", "y"}},
+		{"a👋", []string{"a", "👋"}},
+		{"a\xff\xfeé", []string{"a", "�", "�", "é"}},
+		{"", []string{}},
+	}
+	for _, tt := range tests {
+		got := SplitRunes(tt.s)
+		if got == nil || !slices.Equal(got, tt.want) {
+			t.Errorf("SplitRunes(%q) = %#v, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestCountOccurrences(t *testing.T) {
+	tests := []struct {
+		s, sub      string
+		overlapping bool
+		want        int
+	}{
+		{"aaa", "aa", false, 1},
+		{"aaa", "aa", true, 2},
+		{"abababa", "aba", false, 2},
+		{"abababa", "aba", true, 3},
+		{"héllo", "", false, 6}, // CharCount + 1, not len + 1
+		{"héllo", "", true, 6},
+		{"ééé", "éé", true, 2},
+		{"ééé", "éé", false, 1},
+		{"abc", "x", true, 0},
+	}
+	for _, tt := range tests {
+		if got := CountOccurrences(tt.s, tt.sub, tt.overlapping); got != tt.want {
+			t.Errorf("CountOccurrences(%q, %q, %t) = %d, want %d", tt.s, tt.sub, tt.overlapping, got, tt.want)
+		}
+		if !tt.overlapping {
+			if want := strings.Count(tt.s, tt.sub); tt.want != want {
+				t.Errorf("CountOccurrences(%q, %q, false) = %d, strings.Count = %d", tt.s, tt.sub, tt.want, want)
+			}
+		}
+	}
+}
+
+func TestRepeatToWidth(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"ab", 5, "ababa"},
+		{"ab", 4, "abab"},
+		{"abc", 2, "ab"},
+		{"éà", 3, "éàé"},
+		{"👋x", 3, "👋x👋"},
+		{"", 5, ""},
+		{"ab", 0, ""},
+	}
+	for _, tt := range tests {
+		got := RepeatToWidth(tt.s, tt.width)
+		if got != tt.want {
+			t.Errorf("RepeatToWidth(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("RepeatToWidth(%q, %d) cut a rune: %q", tt.s, tt.width, got)
+		}
+	}
+}
+
+func TestLevenshteinDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"kitten", "kitten", 0},
+		{"", "", 0},
+		{"cat", "cats", 1},  // insertion
+		{"cat", "cut", 1},   // substitution
+		{"café", "cafe", 1}, // é is one rune, not two bytes
+		{"kitten", "sitting", 3},
+		{"", "héllo", 5},
+		{"👋🌍", "🌍", 1},
+	}
+	for _, tt := range tests {
+		if got := LevenshteinDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("LevenshteinDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := LevenshteinDistance(tt.b, tt.a); got != tt.want {
+			t.Errorf("LevenshteinDistance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+		}
+	}
+}
+
+func TestChunkString(t *testing.T) {
+	tests := []struct {
+		s    string
+		size int
+		want []string
+	}{
+		{"abcdef", 2, []string{"ab", "cd", "ef"}},
+		{"abcdefg", 3, []string{"abc", "def", "g"}},
+		{"héllo", 2, []string{"hé", "ll", "o"}},
+		{"👋🌍🎉", 2, []string{"👋🌍", "🎉"}},
+		{"abc", 5, []string{"abc"}},
+		{"abc", 0, []string{"abc"}},
+		{"abc", -1, []string{"abc"}},
+		{"", 2, []string{}},
+	}
+	for _, tt := range tests {
+		got := ChunkString(tt.s, tt.size)
+		if got == nil || !slices.Equal(got, tt.want) {
+			t.Errorf("ChunkString(%q, %d) = %#v, want %q", tt.s, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestIsPalindrome(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"A man, a plan, a canal: Panama", true},
+		{"RaceCar", true},
+		{"été", true},
+		{"Évé", true}, // É lowercases to é
+		{"No 'x' in Nixon", true},
+		{"12321", true},
+		{"", true},
+		{"hello", false},
+	}
+	for _, tt := range tests {
+		if got := IsPalindrome(tt.s); got != tt.want {
+			t.Errorf("IsPalindrome(%q) = %t, want %t", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeWhitespace(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"  hello   world  ", "hello world"},
+		{"a\tb\nc\r\nd", "a b c d"},
+		{"non\u00a0breaking\u00a0\u00a0space", "non breaking space"},
+		{"em\u2003space and\u3000ideographic", "em space and ideographic"},
+		{"\t\n ", ""},
+		{"already clean", "already clean"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeWhitespace(tt.in); got != tt.want {
+			t.Errorf("NormalizeWhitespace(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCharacterAtByte(t *testing.T) {
+	data := []byte("é!") // C3 A9 21
+	tests := []struct {
+		index int
+		want  byte
+	}{
+		{0, 0xC3},
+		{1, 0xA9},
+		{-1, '!'},
+		{-3, 0xC3},
+	}
+	for _, tt := range tests {
+		got, err := CharacterAtByte(data, tt.index)
+		if err != nil || got != tt.want {
+			t.Errorf("CharacterAtByte(% x, %d) = %#x, %v, want %#x", data, tt.index, got, err, tt.want)
+		}
+	}
+	for _, index := range []int{3, -4} {
+		_, err := CharacterAtByte(data, index)
+		want := fmt.Sprintf("attempted to access index %d out of range", index)
+		if err == nil || err.Error() != want {
+			t.Errorf("CharacterAtByte(% x, %d) error = %v, want %q", data, index, err, want)
+		}
+	}
+}
+
+func TestMaskString(t *testing.T) {
+	tests := []struct {
+		s          string
+		start, end int
+		want       string
+	}{
+		{"4111222233334444", 4, -4, "4111********4444"},
+		{"secret", 0, 6, "******"},
+		{"héllo wörld", 1, 5, "h**** wörld"},
+		{"héllo", 2, 2, "héllo"},
+	}
+	for _, tt := range tests {
+		got, err := MaskString(tt.s, tt.start, tt.end, '*')
+		if err != nil || got != tt.want {
+			t.Errorf("MaskString(%q, %d, %d, '*') = %q, %v, want %q", tt.s, tt.start, tt.end, got, err, tt.want)
+		}
+	}
+	if got, _ := MaskString("olá", 0, 3, '•'); got != "•••" {
+		t.Errorf("MaskString with a multibyte mask = %q, want %q", got, "•••")
+	}
+
+	// Bad ranges fail the way Substring does.
+	for _, r := range [][2]int{{3, 1}, {0, 6}, {-7, 2}} {
+		_, err := MaskString("héllo", r[0], r[1], '*')
+		_, want := Substring("héllo", r[0], r[1])
+		if err == nil || err.Error() != want.Error() {
+			t.Errorf("MaskString(%q, %d, %d) error = %v, want Substring's %v", "héllo", r[0], r[1], err, want)
+		}
+	}
+}
+
+func TestFirstDifference(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		index int
+		equal bool
+	}{
+		{"héllo", "héllo", -1, true},
+		{"", "", -1, true},
+		{"hello", "help!", 3, false},
+		{"abc", "abcdef", 3, false}, // prefix: the length of the shorter one
+		{"abcdef", "abc", 3, false},
+		{"café au lait", "cafè au lait", 3, false}, // byte offset 4
+		{"\u00e9", "e\u0301", 0, false},            // precomposed vs decomposed
+	}
+	for _, tt := range tests {
+		index, equal := FirstDifference(tt.a, tt.b)
+		if index != tt.index || equal != tt.equal {
+			t.Errorf("FirstDifference(%q, %q) = %d, %t, want %d, %t", tt.a, tt.b, index, equal, tt.index, tt.equal)
+		}
+	}
+}
+
+func TestStripAccents(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"café résumé", "cafe resume"},
+		{"Ångström naïve façade", "Angstrom naive facade"},
+		{"Việt Nam", "Viet Nam"}, // Latin Extended Additional
+		{"Ǎ ǐ ǒ", "A i o"},       // Latin Extended-B
+		{"cafe\u0301", "cafe"},   // decomposed
+		{"hello world", "hello world"},
+		{"pizza 🍕", "pizza 🍕"},
+		{"straße Ελλάδα", "straße Ελλάδα"}, // no Latin base letter to fall back to
+	}
+	for _, tt := range tests {
+		if got := StripAccents(tt.in); got != tt.want {
+			t.Errorf("StripAccents(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCharFrequency(t *testing.T) {
+	tests := []struct {
+		s            string
+		ignoreSpaces bool
+		want         map[rune]int
+	}{
+		{"héé", false, map[rune]int{'h': 1, 'é': 2}},
+		{"banana", false, map[rune]int{'b': 1, 'a': 3, 'n': 2}},
+		{"a b\tb", false, map[rune]int{'a': 1, ' ': 1, '\t': 1, 'b': 2}},
+		{"a b\tb", true, map[rune]int{'a': 1, 'b': 2}},
+		{"日本 日 ", true, map[rune]int{'日': 2, '本': 1}},
+		{"", false, map[rune]int{}},
+	}
+	for _, tt := range tests {
+		if got := CharFrequency(tt.s, tt.ignoreSpaces); !maps.Equal(got, tt.want) {
+			t.Errorf("CharFrequency(%q, %t) = %v, want %v", tt.s, tt.ignoreSpaces, got, tt.want)
+		}
+	}
+}
+
+func TestTruncateWithEllipsis(t *testing.T) {
+	tests := []struct {
+		s        string
+		maxRunes int
+		ellipsis string
+		want     string
+	}{
+		{"héllo world", 8, "...", "héllo..."},
+		{"héllo", 5, "...", "héllo"}, // fits, nothing cut
+		{"héllo", 10, "...", "héllo"},
+		{"日本語のテキスト", 4, "…", "日本語…"},
+		{"hello", 2, "...", ".."}, // ellipsis cut down to fit
+		{"hello", 3, "", "hel"},
+		{"hello", 0, "...", ""},
+		{"hello", -1, "...", ""},
+		{"", 0, "...", ""},
+	}
+	for _, tt := range tests {
+		got := TruncateWithEllipsis(tt.s, tt.maxRunes, tt.ellipsis)
+		if got != tt.want {
+			t.Errorf("TruncateWithEllipsis(%q, %d, %q) = %q, want %q", tt.s, tt.maxRunes, tt.ellipsis, got, tt.want)
+		}
+		if n := utf8.RuneCountInString(got); n > max(tt.maxRunes, 0) {
+			t.Errorf("TruncateWithEllipsis(%q, %d, %q) is %d runes long", tt.s, tt.maxRunes, tt.ellipsis, n)
+		}
+	}
+}
+
+func TestIndexOfRune(t *testing.T) {
+	tests := []struct {
+		s, sub string
+		want   int
+	}{
+		{"héllo", "llo", 2}, // strings.Index gives 3
+		{"hello", "llo", 2},
+		{"日本語", "語", 2},
+		{"héllo", "é", 1},
+		{"héllo", "abc", -1},
+		{"héllo", "", 0},
+		{"", "a", -1},
+	}
+	for _, tt := range tests {
+		got := IndexOfRune(tt.s, tt.sub)
+		if got != tt.want {
+			t.Errorf("IndexOfRune(%q, %q) = %d, want %d", tt.s, tt.sub, got, tt.want)
+			continue
+		}
+		// The index points getCharacter at the first rune of sub.
+		if got >= 0 && tt.sub != "" {
+			first, _ := utf8.DecodeRuneInString(tt.sub)
+			if r, err := getCharacter(tt.s, got); err != nil || r != first {
+				t.Errorf("getCharacter(%q, %d) = %q, %v, want %q", tt.s, got, r, err, first)
+			}
+		}
+	}
+}
+
+func TestRuneAtColumn(t *testing.T) {
+	tests := []struct {
+		s        string
+		column   int
+		tabWidth int
+		want     rune
+		wantErr  bool
+	}{
+		{"héllo", 1, 4, 'é', false},
+		{"\tx", 0, 4, '\t', false},
+		{"\tx", 3, 4, '\t', false}, // still inside the tab
+		{"\tx", 4, 4, 'x', false},
+		{"a\tb\tc", 5, 4, 'b', false},
+		{"a\tb\tc", 9, 4, '\t', false},
+		{"a\tb\tc", 10, 4, 'c', false},
+		{"\tx", 1, 0, 'x', false}, // tab width below 1 counts as 1
+		{"\tx", 5, 4, 0, true},
+		{"abc", -1, 4, 0, true},
+		{"", 0, 4, 0, true},
+	}
+	for _, tt := range tests {
+		got, err := RuneAtColumn(tt.s, tt.column, tt.tabWidth)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("RuneAtColumn(%q, %d, %d) = %q, %v, want %q (error %t)", tt.s, tt.column, tt.tabWidth, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"short line", 20, "short line"},
+		{"  indented", 20, "  indented"}, // fits, so whitespace is kept
+		{"the quick brown fox", 10, "the quick\nbrown fox"},
+		{"café au lait crème", 7, "café au\nlait\ncrème"},
+		{"abcdefghij", 4, "abcd\nefgh\nij"},
+		{"a abcdefgh b", 4, "a\nabcd\nefgh\nb"},
+		{"one two\n\nthree four", 5, "one\ntwo\n\nthree\nfour"},
+		{"anything goes", 0, "anything goes"},
+		{"", 5, ""},
+	}
+	for _, tt := range tests {
+		got := WrapText(tt.s, tt.width)
+		if got != tt.want {
+			t.Errorf("WrapText(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.want)
+		}
+		if tt.width <= 0 {
+			continue
+		}
+		for _, line := range strings.Split(got, "\n") {
+			if n := utf8.RuneCountInString(line); n > tt.width {
+				t.Errorf("WrapText(%q, %d) has the %d character line %q", tt.s, tt.width, n, line)
+			}
+		}
+	}
+}
+
+func TestSwapCase(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Héllo", "hÉLLO"},
+		{"Hello, World 123!", "hELLO, wORLD 123!"},
+		{"ÉCOLE école", "école ÉCOLE"},
+		{"Ελλάδα", "εΛΛΆΔΑ"},
+		{"ı", "I"}, // 2 bytes to 1
+		{"日本語 🍕", "日本語 🍕"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got := SwapCase(tt.in)
+		if got != tt.want {
+			t.Errorf("SwapCase(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("SwapCase(%q) = %q is not valid UTF-8", tt.in, got)
+		}
+	}
+
+	// Swapping twice gives back letters with a one-to-one case mapping.
+	for _, s := range []string{"Héllo", "Hello, World 123!", "ÉCOLE école", "Ελλάδα"} {
+		if got := SwapCase(SwapCase(s)); got != s {
+			t.Errorf("SwapCase(SwapCase(%q)) = %q, want it back", s, got)
+		}
+	}
+}
+
+func TestParseCharacterRange(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []rune
+	}{
+		{"a-e", []rune("abcde")},
+		{"a-cx", []rune("abcx")},
+		{"A-Ca-c", []rune("ABCabc")},
+		{"a-cxyz", []rune("abcxyz")},
+		{"0-3_", []rune("0123_")},
+		{"à-ä", []rune("àáâãä")},
+		{"α-δ", []rune("αβγδ")},
+		{"a-a", []rune("a")},
+		{"xyz", []rune("xyz")},
+		{"\uD7FF-\uE000", []rune("\uD7FF\uE000")}, // surrogates skipped
+	}
+	for _, tt := range tests {
+		got, err := ParseCharacterRange(tt.spec)
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("ParseCharacterRange(%q) = %q, %v, want %q", tt.spec, got, err, tt.want)
+		}
+		for _, r := range got {
+			if !utf8.ValidRune(r) {
+				t.Errorf("ParseCharacterRange(%q) yields the invalid rune %U", tt.spec, r)
+			}
+		}
+	}
+
+	for _, spec := range []string{"", "z-a", "-a", "a-", "a--b", "a-c-", "\xffa-c"} {
+		if got, err := ParseCharacterRange(spec); err == nil {
+			t.Errorf("ParseCharacterRange(%q) = %q, want an error", spec, got)
+		}
+	}
+}
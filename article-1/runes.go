@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// At returns the element of s at index, or the zero value and an error when
+// index is out of range. Negative indices count from the end.
+func At[T any](s []T, index int) (T, error) {
+	pos, ok := normalizeIndex(index, len(s))
+	if !ok {
+		var zero T
+		return zero, errOutOfRange(index)
+	}
+	return s[pos], nil
+}
+
+// CharacterAt is getCharacter that also returns the byte offset at which the
+// character starts, so callers can slice str from there.
+func CharacterAt(str string, index int) (char rune, byteOffset int, err error) {
+	pos, ok := normalizeIndex(index, utf8.RuneCountInString(str))
+	if !ok {
+		return 0, 0, errOutOfRange(index)
+	}
+
+	for i := 0; ; i++ {
+		r, size, _ := DecodeFirstRune(str[byteOffset:])
+		if i == pos {
+			return r, byteOffset, nil
+		}
+		byteOffset += size
+	}
+}
+
+// DecodeFirstRune decodes the first character of s and returns it with its
+// size in bytes. Unlike utf8.DecodeRuneInString, it says explicitly whether
+// the encoding was valid: valid is false when s starts with a bad byte,
+// which decodes as utf8.RuneError of size 1, and when s is empty.
+func DecodeFirstRune(s string) (r rune, size int, valid bool) {
+	r, size = utf8.DecodeRuneInString(s)
+	return r, size, size > 0 && (r != utf8.RuneError || size > 1)
+}
+
+// GetCharacterUTF16 is getCharacter with index counted in UTF-16 code units,
+// as JavaScript does. Characters outside the Basic Multilingual Plane, such
+// as most emoji, take two units, so both of their indices return them.
+func GetCharacterUTF16(str string, index int) (rune, error) {
+	if index < 0 {
+		return 0, errOutOfRange(index)
+	}
+	unit := 0
+	for _, r := range str {
+		unit += utf16.RuneLen(r)
+		if index < unit {
+			return r, nil
+		}
+	}
+	return 0, errOutOfRange(index)
+}
+
+// RuneAtColumn is getCharacter with index counted in display columns from
+// 0, where every tab takes tabWidth columns and other characters one, so any
+// column covered by a tab returns '\t'. A tabWidth below 1 counts as 1.
+// Columns past the end or negative return the out-of-range error.
+func RuneAtColumn(s string, column, tabWidth int) (rune, error) {
+	if column < 0 {
+		return 0, errOutOfRange(column)
+	}
+	tabWidth = max(tabWidth, 1)
+	col := 0
+	for _, r := range s {
+		if r == '\t' {
+			col += tabWidth
+		} else {
+			col++
+		}
+		if column < col {
+			return r, nil
+		}
+	}
+	return 0, errOutOfRange(column)
+}
+
+// CharacterAtByte is the byte-level counterpart of getCharacter: it returns
+// the byte at index in data, with the same negative indexing and
+// out-of-range error. For "é", index 0 is 0xC3, the first byte of its UTF-8
+// encoding, where getCharacter returns 'é' itself.
+func CharacterAtByte(data []byte, index int) (byte, error) {
+	return At(data, index)
+}
+
+// Substring returns the runes of str from start (inclusive) to end
+// (exclusive). Like getCharacter, indices count runes and may be negative to
+// count from the end. It fails when an index is out of range or start comes
+// after end.
+func Substring(str string, start, end int) (string, error) {
+	runes := []rune(str)
+	from, to, err := normalizeRange(start, end, len(runes))
+	if err != nil {
+		return "", err
+	}
+	return string(runes[from:to]), nil
+}
+
+// normalizeRange maps a possibly negative [start, end) range onto
+// [0, length], where end may equal length.
+func normalizeRange(start, end, length int) (int, int, error) {
+	from, to := start, end
+	if from < 0 {
+		from += length
+	}
+	if to < 0 {
+		to += length
+	}
+	if from < 0 || from > length {
+		return 0, 0, errOutOfRange(start)
+	}
+	if to < 0 || to > length {
+		return 0, 0, errOutOfRange(end)
+	}
+	if from > to {
+		return 0, 0, fmt.Errorf("invalid range [%d, %d): start is after end", start, end)
+	}
+	return from, to, nil
+}
+
+// ByteCount returns the number of bytes in s, which is what len reports.
+func ByteCount(s string) int {
+	return len(s)
+}
+
+// CharCount returns the number of characters (runes) in s. Each byte of an
+// invalid UTF-8 sequence counts as one character, the same way ranging over
+// the string yields one utf8.RuneError per bad byte.
+func CharCount(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+// FindAll returns the rune indices at which target occurs in str, in the
+// same indexing as getCharacter. It returns an empty slice when there is no
+// match. Because whole runes are compared, a multibyte target never matches
+// part of another character.
+func FindAll(str string, target rune) []int {
+	indices := []int{}
+	i := 0
+	for _, r := range str {
+		if r == target {
+			indices = append(indices, i)
+		}
+		i++
+	}
+	return indices
+}
+
+// IndexOfRune returns the rune index at which sub first occurs in s, the
+// index getCharacter takes, or -1 when it does not occur. strings.Index
+// returns a byte offset instead, so for "héllo" and "llo" it gives 3 where
+// IndexOfRune gives 2.
+func IndexOfRune(s, sub string) int {
+	i := strings.Index(s, sub)
+	if i < 0 {
+		return -1
+	}
+	return utf8.RuneCountInString(s[:i])
+}
+
+// EachCharacter calls fn for every character of str with its rune index, the
+// same index getCharacter takes, and stops as soon as fn returns false.
+func EachCharacter(str string, fn func(index int, char rune) bool) {
+	i := 0
+	for _, r := range str {
+		if !fn(i, r) {
+			return
+		}
+		i++
+	}
+}
+
+// SplitRunes splits s into its characters, one string per rune, so "héy"
+// yields ["h" "é" "y"]. Each byte of an invalid UTF-8 sequence becomes its
+// own "�" entry and leaves the neighbouring characters intact. An empty
+// s yields an empty slice.
+func SplitRunes(s string) []string {
+	chars := make([]string, 0, utf8.RuneCountInString(s))
+	for _, r := range s {
+		chars = append(chars, string(r))
+	}
+	return chars
+}
+
+// ChunkString splits s into consecutive pieces of at most size characters, so
+// ChunkString("héllo", 2) yields ["hé" "ll" "o"]. Pieces are cut between runes,
+// never inside one, and only the last may be shorter. A size of zero or less
+// yields s as a single piece, and an empty s yields an empty slice.
+func ChunkString(s string, size int) []string {
+	if s == "" {
+		return []string{}
+	}
+	if size <= 0 {
+		return []string{s}
+	}
+
+	chunks := make([]string, 0, (utf8.RuneCountInString(s)+size-1)/size)
+	start, n := 0, 0
+	for i := range s {
+		if n == size {
+			chunks = append(chunks, s[start:i])
+			start, n = i, 0
+		}
+		n++
+	}
+	return append(chunks, s[start:])
+}
+
+// FirstDifference returns the index of the first character at which a and b
+// differ, counted in runes so getCharacter finds it in both strings. When
+// one string is a prefix of the other, the index is the length of the
+// shorter one. Identical strings give -1 and equal set to true.
+func FirstDifference(a, b string) (index int, equal bool) {
+	for {
+		ra, sizeA := utf8.DecodeRuneInString(a)
+		rb, sizeB := utf8.DecodeRuneInString(b)
+		if sizeA == 0 && sizeB == 0 {
+			return -1, true
+		}
+		if sizeA != sizeB || ra != rb || a[:sizeA] != b[:sizeB] {
+			return index, false
+		}
+		a, b = a[sizeA:], b[sizeB:]
+		index++
+	}
+}
+
+// ParseCharacterRange expands a character set spec such as "a-z", "A-Za-z"
+// or "a-cxyz" into the runes it covers, in the order given, so "a-cx" yields
+// ['a' 'b' 'c' 'x']. A range takes every rune from its first to its last
+// character, multibyte ones included, except the surrogates U+D800–U+DFFF,
+// which are not characters and would only become U+FFFD in a string. It
+// fails on an empty spec, a range that runs backwards such as "z-a", a '-'
+// without a character on both sides, and invalid UTF-8.
+func ParseCharacterRange(spec string) ([]rune, error) {
+	if spec == "" {
+		return nil, errors.New("invalid character range: empty spec")
+	}
+	if !utf8.ValidString(spec) {
+		return nil, fmt.Errorf("invalid character range %q: not valid UTF-8", spec)
+	}
+
+	src := []rune(spec)
+	var runes []rune
+	for i := 0; i < len(src); i++ {
+		first := src[i]
+		if first == '-' {
+			return nil, fmt.Errorf("invalid character range %q: '-' at index %d has no start", spec, i)
+		}
+		if i+1 >= len(src) || src[i+1] != '-' {
+			runes = append(runes, first)
+			continue
+		}
+		if i+2 >= len(src) {
+			return nil, fmt.Errorf("invalid character range %q: '-' at index %d has no end", spec, i+1)
+		}
+		last := src[i+2]
+		if first > last {
+			return nil, fmt.Errorf("invalid character range %q: %q is after %q", spec, first, last)
+		}
+		for r := first; r <= last; r++ {
+			if utf8.ValidRune(r) {
+				runes = append(runes, r)
+			}
+		}
+		i += 2
+	}
+	return runes, nil
+}
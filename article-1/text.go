@@ -0,0 +1,431 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ReverseString reverses s character by character, so "héllo" becomes
+// "olléh" instead of corrupting the two bytes of 'é'. Combining marks stay
+// attached to the rune before them, so a decomposed "é" is kept
+// together. Other multi-rune graphemes, such as emoji joined with a zero
+// width joiner, are still reversed rune by rune.
+func ReverseString(s string) string {
+	runes := []rune(s)
+	reversed := make([]rune, 0, len(runes))
+	for end := len(runes); end > 0; {
+		start := end - 1
+		for start > 0 && isCombining(runes[start]) {
+			start--
+		}
+		reversed = append(reversed, runes[start:end]...)
+		end = start
+	}
+	return string(reversed)
+}
+
+// isCombining reports whether r is a combining mark that belongs to the
+// rune before it.
+func isCombining(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me)
+}
+
+// WordAt returns the whitespace-delimited word containing the character at
+// index, using getCharacter's indexing and out-of-range error. It returns an
+// empty string when index falls on whitespace.
+func WordAt(str string, index int) (string, error) {
+	runes := []rune(str)
+	pos, ok := normalizeIndex(index, len(runes))
+	if !ok {
+		return "", errOutOfRange(index)
+	}
+	if unicode.IsSpace(runes[pos]) {
+		return "", nil
+	}
+
+	start, end := pos, pos+1
+	for start > 0 && !unicode.IsSpace(runes[start-1]) {
+		start--
+	}
+	for end < len(runes) && !unicode.IsSpace(runes[end]) {
+		end++
+	}
+	return string(runes[start:end]), nil
+}
+
+// TrimRunes removes every leading and trailing character of s that appears
+// in cutset. The cutset is read rune by rune, so a multibyte character such
+// as '…' is trimmed as a whole rather than matching single bytes of other
+// characters. An empty cutset leaves s unchanged.
+func TrimRunes(s string, cutset string) string {
+	if cutset == "" {
+		return s
+	}
+	return strings.TrimFunc(s, func(r rune) bool {
+		return strings.ContainsRune(cutset, r)
+	})
+}
+
+// PadLeft prepends pad to s until it is width characters long. Width counts
+// runes, not bytes, so "é" needs two pad runes to reach a width of 3. A
+// string already at least width characters long is returned unchanged.
+func PadLeft(s string, width int, pad rune) string {
+	return padding(s, width, pad) + s
+}
+
+// PadRight is PadLeft appending the padding instead.
+func PadRight(s string, width int, pad rune) string {
+	return s + padding(s, width, pad)
+}
+
+// padding returns the run of pad that brings s up to width runes.
+func padding(s string, width int, pad rune) string {
+	n := width - utf8.RuneCountInString(s)
+	if n <= 0 {
+		return ""
+	}
+	return strings.Repeat(string(pad), n)
+}
+
+// CaesarShift shifts every ASCII letter of s by shift places, wrapping
+// within a–z and A–Z, and leaves all other characters untouched. A negative
+// shift undoes a positive one, and shifts beyond 26 wrap around.
+func CaesarShift(s string, shift int) string {
+	shift %= 26
+	if shift < 0 {
+		shift += 26
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return 'a' + (r-'a'+rune(shift))%26
+		case r >= 'A' && r <= 'Z':
+			return 'A' + (r-'A'+rune(shift))%26
+		}
+		return r
+	}, s)
+}
+
+// ToTitleCase uppercases the first character of every whitespace-delimited
+// word in s and lowercases the rest, keeping the whitespace as is. Case is
+// changed rune by rune, so "école" becomes "École" even though the two
+// forms of the first letter need not have the same byte length.
+func ToTitleCase(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	wordStart := true
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			wordStart = true
+		case wordStart:
+			r = unicode.ToUpper(r)
+			wordStart = false
+		default:
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// CountOccurrences counts how many times sub appears in s. Without
+// overlapping it matches strings.Count; with overlapping, matches may share
+// characters, so "aa" occurs twice in "aaa". Like strings.Count, an empty
+// sub is counted between every pair of characters and at both ends, giving
+// CharCount(s) + 1.
+func CountOccurrences(s, sub string, overlapping bool) int {
+	if !overlapping || sub == "" {
+		return strings.Count(s, sub)
+	}
+
+	// Restart the search one character after each match start, so the next
+	// match may overlap it without splitting a multibyte rune.
+	count := 0
+	for {
+		i := strings.Index(s, sub)
+		if i < 0 {
+			return count
+		}
+		count++
+		_, size := utf8.DecodeRuneInString(s[i:])
+		s = s[i+size:]
+	}
+}
+
+// RepeatToWidth repeats s until the result is exactly width characters long,
+// cutting the last copy on a rune boundary, so RepeatToWidth("ab", 5) is
+// "ababa". An empty s, or a width of zero or less, gives an empty string.
+func RepeatToWidth(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) == 0 || width <= 0 {
+		return ""
+	}
+	repeated := make([]rune, width)
+	for i := range repeated {
+		repeated[i] = runes[i%len(runes)]
+	}
+	return string(repeated)
+}
+
+// LevenshteinDistance returns the number of single-character insertions,
+// deletions and substitutions needed to turn a into b. Characters are runes,
+// so "café" and "cafe" are one substitution apart. It keeps a single row of
+// the usual dynamic-programming table, sized by the shorter string.
+func LevenshteinDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) < len(rb) {
+		ra, rb = rb, ra
+	}
+
+	row := make([]int, len(rb)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		diag := row[0]
+		row[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			next := min(row[j]+1, row[j-1]+1, diag+cost)
+			diag = row[j]
+			row[j] = next
+		}
+	}
+	return row[len(rb)]
+}
+
+// IsPalindrome reports whether s reads the same backwards, looking only at
+// letters and digits and ignoring case, so "A man, a plan, a canal: Panama"
+// and "été" are palindromes. The comparison uses ReverseString, so combining
+// marks stay with their letter and a decomposed "été" is a palindrome too.
+func IsPalindrome(s string) bool {
+	kept := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || isCombining(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+	return ReverseString(kept) == kept
+}
+
+// NormalizeWhitespace trims s and collapses every run of whitespace inside it
+// into a single ASCII space, so " a\t\n b " becomes "a b". Whitespace is
+// anything unicode.IsSpace accepts, including the non-breaking space.
+func NormalizeWhitespace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// MaskString replaces the characters of s from start (inclusive) to end
+// (exclusive) with mask, so MaskString("4111222233334444", 4, -4, '*') gives
+// "4111********4444". Indices follow Substring: they count runes, may be
+// negative to count from the end, and a bad range returns Substring's error
+// rather than being clamped, so a wrong range is never masked silently.
+func MaskString(s string, start, end int, mask rune) (string, error) {
+	runes := []rune(s)
+	from, to, err := normalizeRange(start, end, len(runes))
+	if err != nil {
+		return "", err
+	}
+	for i := from; i < to; i++ {
+		runes[i] = mask
+	}
+	return string(runes), nil
+}
+
+// StripAccents removes the diacritics from Latin letters, so "café résumé"
+// becomes "cafe resume" and "Việt" becomes "Viet". Precomposed Latin letters
+// are replaced by their base letter using accentedLetters, which covers the
+// Latin blocks up to U+024F and Latin Extended Additional, and combining
+// marks following a Latin letter, as in decomposed text, are dropped. This
+// is not full NFD: precomposed letters outside those blocks, and non-Latin
+// text such as "ß", Greek or emoji, are left as they are.
+func StripAccents(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	latin := false
+	for _, r := range s {
+		if base, ok := accentBase[r]; ok {
+			r = base
+		}
+		if latin && isCombining(r) {
+			continue
+		}
+		latin = unicode.Is(unicode.Latin, r)
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// accentBase maps each accented letter of accentedLetters to its base letter.
+var accentBase = func() map[rune]rune {
+	m := make(map[rune]rune)
+	for base, letters := range accentedLetters {
+		for _, r := range letters {
+			m[r] = base
+		}
+	}
+	return m
+}()
+
+// accentedLetters lists, for each base letter, the precomposed letters of
+// Latin-1 Supplement, Latin Extended-A, Latin Extended-B and Latin Extended
+// Additional whose canonical decomposition is that letter plus combining
+// marks. The list was generated from the Unicode decomposition data.
+var accentedLetters = map[rune]string{
+	'A': "ÀÁÂÃÄÅĀĂĄǍǞǠǺȀȂȦḀẠẢẤẦẨẪẬẮẰẲẴẶ",
+	'a': "àáâãäåāăąǎǟǡǻȁȃȧḁạảấầẩẫậắằẳẵặ",
+	'B': "ḂḄḆ",
+	'b': "ḃḅḇ",
+	'C': "ÇĆĈĊČḈ",
+	'c': "çćĉċčḉ",
+	'D': "ĎḊḌḎḐḒ",
+	'd': "ďḋḍḏḑḓ",
+	'E': "ÈÉÊËĒĔĖĘĚȄȆȨḔḖḘḚḜẸẺẼẾỀỂỄỆ",
+	'e': "èéêëēĕėęěȅȇȩḕḗḙḛḝẹẻẽếềểễệ",
+	'F': "Ḟ",
+	'f': "ḟ",
+	'G': "ĜĞĠĢǦǴḠ",
+	'g': "ĝğġģǧǵḡ",
+	'H': "ĤȞḢḤḦḨḪ",
+	'h': "ĥȟḣḥḧḩḫẖ",
+	'I': "ÌÍÎÏĨĪĬĮİǏȈȊḬḮỈỊ",
+	'i': "ìíîïĩīĭįǐȉȋḭḯỉị",
+	'J': "Ĵ",
+	'j': "ĵǰ",
+	'K': "ĶǨḰḲḴ",
+	'k': "ķǩḱḳḵ",
+	'L': "ĹĻĽḶḸḺḼ",
+	'l': "ĺļľḷḹḻḽ",
+	'M': "ḾṀṂ",
+	'm': "ḿṁṃ",
+	'N': "ÑŃŅŇǸṄṆṈṊ",
+	'n': "ñńņňǹṅṇṉṋ",
+	'O': "ÒÓÔÕÖŌŎŐƠǑǪǬȌȎȪȬȮȰṌṎṐṒỌỎỐỒỔỖỘỚỜỞỠỢ",
+	'o': "òóôõöōŏőơǒǫǭȍȏȫȭȯȱṍṏṑṓọỏốồổỗộớờởỡợ",
+	'P': "ṔṖ",
+	'p': "ṕṗ",
+	'R': "ŔŖŘȐȒṘṚṜṞ",
+	'r': "ŕŗřȑȓṙṛṝṟ",
+	'S': "ŚŜŞŠȘṠṢṤṦṨ",
+	's': "śŝşšșṡṣṥṧṩ",
+	'T': "ŢŤȚṪṬṮṰ",
+	't': "ţťțṫṭṯṱẗ",
+	'U': "ÙÚÛÜŨŪŬŮŰŲƯǓǕǗǙǛȔȖṲṴṶṸṺỤỦỨỪỬỮỰ",
+	'u': "ùúûüũūŭůűųưǔǖǘǚǜȕȗṳṵṷṹṻụủứừửữự",
+	'V': "ṼṾ",
+	'v': "ṽṿ",
+	'W': "ŴẀẂẄẆẈ",
+	'w': "ŵẁẃẅẇẉẘ",
+	'X': "ẊẌ",
+	'x': "ẋẍ",
+	'Y': "ÝŶŸȲẎỲỴỶỸ",
+	'y': "ýÿŷȳẏẙỳỵỷỹ",
+	'Z': "ŹŻŽẐẒẔ",
+	'z': "źżžẑẓẕ",
+	'Æ': "ǢǼ",
+	'æ': "ǣǽ",
+	'Ø': "Ǿ",
+	'ø': "ǿ",
+	'ſ': "ẛ",
+	'Ʒ': "Ǯ",
+	'ʒ': "ǯ",
+}
+
+// CharFrequency counts how many times each character occurs in s, by rune,
+// so "héé" gives {'h': 1, 'é': 2}. With ignoreSpaces set, whitespace
+// characters are left out of the counts.
+func CharFrequency(s string, ignoreSpaces bool) map[rune]int {
+	freq := make(map[rune]int)
+	for _, r := range s {
+		if ignoreSpaces && unicode.IsSpace(r) {
+			continue
+		}
+		freq[r]++
+	}
+	return freq
+}
+
+// TruncateWithEllipsis shortens s to at most maxRunes characters, ending it
+// with ellipsis when anything was cut, so TruncateWithEllipsis("héllo world",
+// 8, "...") gives "héllo...". The ellipsis counts towards maxRunes; when it
+// alone is longer, it is cut down to maxRunes itself. A maxRunes of zero or
+// less gives an empty string.
+func TruncateWithEllipsis(s string, maxRunes int, ellipsis string) string {
+	runes := []rune(s)
+	if len(runes) <= maxRunes {
+		return s
+	}
+	if maxRunes <= 0 {
+		return ""
+	}
+	dots := []rune(ellipsis)
+	if len(dots) >= maxRunes {
+		return string(dots[:maxRunes])
+	}
+	return string(runes[:maxRunes-len(dots)]) + ellipsis
+}
+
+// WrapText breaks the lines of s so that none is longer than width
+// characters, counted in runes. Lines that already fit are left exactly as
+// they are. Longer ones break at whitespace, which is dropped at the break,
+// and words longer than width are split across lines. Existing newlines are
+// kept, so paragraphs stay apart. A width of zero or less leaves s as it is.
+func WrapText(s string, width int) string {
+	if width <= 0 {
+		return s
+	}
+	paragraphs := strings.Split(s, "\n")
+	for i, p := range paragraphs {
+		paragraphs[i] = wrapParagraph(p, width)
+	}
+	return strings.Join(paragraphs, "\n")
+}
+
+// wrapParagraph wraps a single line of text for WrapText.
+func wrapParagraph(p string, width int) string {
+	if utf8.RuneCountInString(p) <= width {
+		return p
+	}
+	var lines []string
+	var line []rune
+	for _, word := range strings.Fields(p) {
+		w := []rune(word)
+		if len(line) > 0 && len(line)+1+len(w) <= width {
+			line = append(append(line, ' '), w...)
+			continue
+		}
+		if len(line) > 0 {
+			lines = append(lines, string(line))
+		}
+		for len(w) > width {
+			lines = append(lines, string(w[:width]))
+			w = w[width:]
+		}
+		line = w
+	}
+	if len(line) > 0 {
+		lines = append(lines, string(line))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// SwapCase turns upper case letters of s into lower case and lower case
+// letters into upper case, leaving everything else alone, so "Héllo" becomes
+// "hÉLLO". It works rune by rune, so letters whose other case takes a
+// different number of bytes, such as 'ı' and 'I', come out whole.
+func SwapCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsUpper(r):
+			return unicode.ToLower(r)
+		case unicode.IsLower(r):
+			return unicode.ToUpper(r)
+		}
+		return r
+	}, s)
+}
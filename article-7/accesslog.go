@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// AccessLogFormat selects the line WithAccessLog writes for each request.
+type AccessLogFormat int
+
+const (
+	// CommonLogFormat is the NCSA common log format:
+	// host ident user [time] "request" status bytes.
+	CommonLogFormat AccessLogFormat = iota + 1
+	// CombinedLogFormat is CommonLogFormat followed by the quoted referer
+	// and user agent.
+	CombinedLogFormat
+)
+
+// clfTime is the timestamp layout of the common log format.
+const clfTime = "02/Jan/2006:15:04:05 -0700"
+
+func (f AccessLogFormat) String() string {
+	switch f {
+	case CommonLogFormat:
+		return "common"
+	case CombinedLogFormat:
+		return "combined"
+	}
+	return "unknown"
+}
+
+// accessLog writes one line per request to the server's logger, in the
+// s.accessLogFormat format, followed by how long the request took.
+func (s *Server) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, r)
+		dur := time.Since(start)
+
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		user := "-"
+		if name, _, ok := r.BasicAuth(); ok && name != "" {
+			user = name
+		}
+		size := "-"
+		if rec.bytes > 0 {
+			size = strconv.FormatInt(rec.bytes, 10)
+		}
+		line := fmt.Sprintf("%s - %s [%s] %q %d %s", host, user, start.Format(clfTime),
+			r.Method+" "+r.RequestURI+" "+r.Proto, rec.statusCode(), size)
+		if s.accessLogFormat == CombinedLogFormat {
+			line += fmt.Sprintf(" %q %q", r.Referer(), r.UserAgent())
+		}
+		s.logger.Printf("%s %s", line, dur)
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// limitBody caps request bodies at n bytes. A request whose Content-Length
+// is already over the limit gets a 413 without reaching next. For the others
+// the body is wrapped in http.MaxBytesReader, and once a read hits the limit
+// the response goes out as a 413, whatever status next picks.
+func limitBody(n int64, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > n {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		lw := &limitedWriter{ResponseWriter: w}
+		r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, n), w: lw}
+		next.ServeHTTP(lw, r)
+		if lw.tooLarge.Load() && !lw.wroteHeader {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		}
+	})
+}
+
+// limitedBody tells its writer when a read went past the limit.
+type limitedBody struct {
+	io.ReadCloser
+	w *limitedWriter
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		b.w.tooLarge.Store(true)
+	}
+	return n, err
+}
+
+// limitedWriter turns the status of the response into a 413 once the
+// request body turned out to be too large.
+type limitedWriter struct {
+	http.ResponseWriter
+	tooLarge    atomic.Bool // set from the handler's goroutine
+	wroteHeader bool
+}
+
+func (w *limitedWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if w.tooLarge.Load() {
+		code = http.StatusRequestEntityTooLarge
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *limitedWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *limitedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush forwards to the underlying writer, see statusRecorder.Flush.
+func (w *limitedWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack forwards to the underlying writer, see statusRecorder.Hijack.
+func (w *limitedWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
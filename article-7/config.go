@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+// serverConfig is the JSON form of a Server configuration. The timeout is a
+// duration string such as "3s".
+type serverConfig struct {
+	Host    string `json:"host"`
+	Port    int    `json:"port"`
+	Timeout string `json:"timeout"`
+}
+
+// LoadServerFromJSON creates a Server from a JSON config read from r.
+// Missing fields fall back to the defaults and unknown fields are an error.
+func LoadServerFromJSON(r io.Reader) (*Server, error) {
+	cfg := serverConfig{
+		Host:    DefaultHost,
+		Port:    DefaultPort,
+		Timeout: DefaultTimeout.String(),
+	}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("decode server config: %w", err)
+	}
+
+	timeout, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout %q: %w", cfg.Timeout, err)
+	}
+	return NewServer(cfg.Host, cfg.Port, timeout)
+}
+
+// MarshalJSON encodes the server configuration in the format read by
+// LoadServerFromJSON.
+func (s *Server) MarshalJSON() ([]byte, error) {
+	return json.Marshal(serverConfig{
+		Host:    s.host,
+		Port:    s.port,
+		Timeout: s.timeout.String(),
+	})
+}
+
+// NewServerFromEnv creates a Server from the SERVER_HOST, SERVER_PORT and
+// SERVER_TIMEOUT environment variables. Unset variables fall back to the
+// defaults; SERVER_TIMEOUT uses Go duration syntax such as "5s" or "1m".
+func NewServerFromEnv() (*Server, error) {
+	host := DefaultHost
+	if v, ok := os.LookupEnv("SERVER_HOST"); ok {
+		host = v
+	}
+
+	port := DefaultPort
+	if v, ok := os.LookupEnv("SERVER_PORT"); ok {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SERVER_PORT %q: %w", v, err)
+		}
+		port = p
+	}
+
+	timeout := DefaultTimeout
+	if v, ok := os.LookupEnv("SERVER_TIMEOUT"); ok {
+		t, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SERVER_TIMEOUT %q: %w", v, err)
+		}
+		timeout = t
+	}
+
+	return NewServer(host, port, timeout)
+}
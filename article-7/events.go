@@ -0,0 +1,60 @@
+package main
+
+// ServerState is a lifecycle transition published on the Events channel.
+type ServerState int
+
+const (
+	StateStarting ServerState = iota
+	StateRunning
+	StateStopping
+	StateStopped
+)
+
+// eventBuffer is how many transitions are kept for a slow consumer before
+// new ones are dropped.
+const eventBuffer = 16
+
+func (st ServerState) String() string {
+	switch st {
+	case StateStarting:
+		return "starting"
+	case StateRunning:
+		return "running"
+	case StateStopping:
+		return "stopping"
+	case StateStopped:
+		return "stopped"
+	}
+	return "unknown"
+}
+
+// Events returns a channel on which the server publishes its lifecycle
+// transitions. The channel is buffered and transitions are dropped when it
+// is full, so a slow consumer never blocks the server. Every call returns
+// the same channel, which is never closed.
+func (s *Server) Events() <-chan ServerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.events == nil {
+		s.events = make(chan ServerState, eventBuffer)
+	}
+	return s.events
+}
+
+// emit publishes state without blocking.
+func (s *Server) emit(state ServerState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.emitLocked(state)
+}
+
+// emitLocked is emit with s.mu already held.
+func (s *Server) emitLocked(state ServerState) {
+	if s.events == nil {
+		return
+	}
+	select {
+	case s.events <- state:
+	default:
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// gzipResponses compresses the responses to clients that accept gzip once
+// their body grows past minSize bytes. Smaller bodies, partial responses and
+// responses the handler already encoded are sent as they are.
+func gzipResponses(minSize int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipWriter{ResponseWriter: w, minSize: minSize}
+		next.ServeHTTP(gw, r)
+		gw.finish()
+	})
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of r lists gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(enc, ";")
+		if strings.TrimSpace(name) == "gzip" && strings.TrimSpace(params) != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipWriter holds back the status and the start of the body until either
+// minSize bytes have been written, when it switches to gzip, or the handler
+// is done, when it sends the body uncompressed.
+type gzipWriter struct {
+	http.ResponseWriter
+	minSize int
+	status  int
+	buf     []byte
+	started bool
+	gz      *gzip.Writer
+}
+
+func (w *gzipWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.started {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) <= w.minSize {
+			return len(b), nil
+		}
+		if err := w.start(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// start sends the held back status and body, compressed or not.
+func (w *gzipWriter) start(compress bool) error {
+	w.started = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	h := w.ResponseWriter.Header()
+	// A partial response describes byte ranges of the uncompressed body, so
+	// compressing it would make its Content-Range wrong.
+	partial := w.status == http.StatusPartialContent || h.Get("Content-Range") != ""
+	if compress && !partial && h.Get("Content-Encoding") == "" {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.gz != nil {
+		_, err = w.gz.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// finish sends a response that stayed under minSize and closes the gzip
+// stream, writing its footer.
+func (w *gzipWriter) finish() {
+	if !w.started {
+		if w.status == 0 && len(w.buf) == 0 {
+			return
+		}
+		w.start(false)
+	}
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
+
+// Flush sends what has been written so far, deciding on compression early if
+// needed, so streaming handlers keep working.
+func (w *gzipWriter) Flush() {
+	if !w.started {
+		w.start(len(w.buf) > w.minSize)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *gzipWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Hijack forwards to the underlying writer, see statusRecorder.Hijack.
+// Nothing buffered is sent, since the connection now belongs to the caller.
+func (w *gzipWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
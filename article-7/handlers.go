@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware wraps an http.Handler with extra behaviour.
+type Middleware func(http.Handler) http.Handler
+
+// Use appends mw to the middleware chain. Middleware registered first runs
+// outermost. The chain is fixed once the server runs, so Use fails while it
+// is running.
+func (s *Server) Use(mw Middleware) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return fmt.Errorf("use middleware: %w", ErrServerRunning)
+	}
+	s.middleware = append(s.middleware, mw)
+	return nil
+}
+
+// route is an application route registered with Handle.
+type route struct {
+	pattern string
+	handler http.Handler
+	timeout time.Duration // replaces the server's timeout when set
+}
+
+// Handle registers handler for pattern, using http.ServeMux pattern syntax.
+// Routes must be registered before the server runs. An invalid pattern, or
+// one that conflicts with a route registered earlier, is returned as an
+// error.
+func (s *Server) Handle(pattern string, handler http.Handler) error {
+	return s.addRoute(route{pattern: pattern, handler: handler})
+}
+
+// HandleFunc registers fn for pattern, see Handle.
+func (s *Server) HandleFunc(pattern string, fn http.HandlerFunc) error {
+	if fn == nil {
+		return fmt.Errorf("handle %s: nil handler", pattern)
+	}
+	return s.Handle(pattern, fn)
+}
+
+// HandleFuncTimeout registers fn for pattern like HandleFunc, with d in place
+// of the server's timeout for this route only, so one slow endpoint can get a
+// longer budget, or a quick one a shorter one. d must be positive.
+func (s *Server) HandleFuncTimeout(pattern string, fn http.HandlerFunc, d time.Duration) error {
+	if fn == nil {
+		return fmt.Errorf("handle %s: nil handler", pattern)
+	}
+	if d <= 0 {
+		return fmt.Errorf("handle %s: invalid timeout %s: must be positive", pattern, d)
+	}
+	return s.addRoute(route{pattern: pattern, handler: fn, timeout: d})
+}
+
+// addRoute registers rt unless the server is running.
+func (s *Server) addRoute(rt route) (err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return fmt.Errorf("handle %s: %w", rt.pattern, ErrServerRunning)
+	}
+	// http.ServeMux panics on bad patterns and nil handlers.
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("handle %s: %v", rt.pattern, p)
+		}
+	}()
+	s.mux.Handle(rt.pattern, rt.handler)
+	s.routes = append(s.routes, rt)
+	return nil
+}
+
+// handler builds the root handler served by Run, including the built-in
+// endpoints next to the application routes, wrapped in the registered
+// middleware. s.mu must be held.
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	for _, d := range s.static {
+		mux.Handle(d.pattern(), d.handler())
+	}
+	mux.Handle("/", s.mux)
+
+	var h http.Handler = mux
+	// Handlers that take longer than their timeout get a 503 instead.
+	timeoutFor := s.timeoutFor(mux)
+	if timeoutFor != nil {
+		h = withTimeout(timeoutFor, h)
+	}
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		h = s.middleware[i](h)
+	}
+	if timeoutFor != nil {
+		h = withDeadline(timeoutFor, h)
+	}
+	if s.maxBodyBytes > 0 {
+		h = limitBody(s.maxBodyBytes, h)
+	}
+	if s.rateLimit > 0 {
+		h = rateLimit(newTokenBucket(s.rateLimit, s.rateBurst), h)
+	}
+	if s.gzipOn {
+		h = gzipResponses(s.gzipMin, h)
+	}
+	if len(s.corsOrigins) > 0 {
+		h = cors(s.corsOrigins, h)
+	}
+	if !s.recoveryOff {
+		h = s.recoverPanics(h)
+	}
+	if s.requestHook != nil {
+		h = s.observe(h)
+	}
+	if s.accessLogFormat != 0 {
+		h = s.accessLog(h)
+	}
+	return s.countRequests(withRequestID(h))
+}
+
+// observe calls the request hook once next has responded, with the status
+// sent and how long it took. A panicking handler is reported with a 500
+// before the panic carries on up the stack.
+func (s *Server) observe(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		defer func() {
+			if p := recover(); p != nil {
+				s.requestHook(r, http.StatusInternalServerError, time.Since(start))
+				panic(p)
+			}
+		}()
+		next.ServeHTTP(rec, r)
+		s.requestHook(r, rec.statusCode(), time.Since(start))
+	})
+}
+
+// recoverPanics turns a panicking handler into a 500, logging the panic and
+// its stack trace, so one bad request does not take the server down.
+// http.ErrAbortHandler is let through, since it asks for exactly that abort.
+func (s *Server) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			s.logger.Printf("Server recovered from panic serving %s %s: %v\n%s", r.Method, r.URL.Path, p, debug.Stack())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// timeoutFor returns a function giving each request's timeout: the one its
+// route was registered with by HandleFuncTimeout, or else the server's, where
+// zero means no limit. It returns nil when no request has a limit. s.mu must
+// be held.
+func (s *Server) timeoutFor(root *http.ServeMux) func(r *http.Request) time.Duration {
+	routes := make(map[string]time.Duration)
+	for _, rt := range s.routes {
+		if rt.timeout > 0 {
+			routes[rt.pattern] = rt.timeout
+		}
+	}
+	if s.timeout == 0 && len(routes) == 0 {
+		return nil
+	}
+
+	app := s.mux
+	return func(r *http.Request) time.Duration {
+		if len(routes) > 0 {
+			// Application routes are all reached through the root's "/".
+			if _, pattern := root.Handler(r); pattern == "/" {
+				if _, pattern := app.Handler(r); routes[pattern] > 0 {
+					return routes[pattern]
+				}
+			}
+		}
+		return s.timeout
+	}
+}
+
+// withTimeout runs next under http.TimeoutHandler with the request's timeout,
+// answering 503 when it runs out.
+func withTimeout(timeoutFor func(*http.Request) time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := timeoutFor(r)
+		if d == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		http.TimeoutHandler(next, d, "").ServeHTTP(w, r)
+	})
+}
+
+// withDeadline gives every request context a deadline its timeout from now,
+// so handlers and middleware can watch r.Context().Done().
+func withDeadline(timeoutFor func(*http.Request) time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d := timeoutFor(r)
+		if d == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), d)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// handleHealthz answers 200 "ok" while the server is healthy and 503 otherwise.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if !s.healthy.Load() {
+		http.Error(w, "unhealthy", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok"))
+}
+
+// SetHealthy changes what GET /healthz reports. Servers start healthy.
+func (s *Server) SetHealthy(healthy bool) {
+	s.healthy.Store(healthy)
+}
+
+// handleReadyz answers 200 "ok" when the readiness probe passes, or when none
+// is set, and 503 with the probe's error otherwise.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if probe := s.readiness.Load(); probe != nil {
+		if err := (*probe)(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	w.Write([]byte("ok"))
+}
+
+// SetReadinessProbe makes GET /readyz call probe on every request and report
+// ready only when it returns nil, for instance once a database answers. It
+// may be swapped while the server runs; a nil probe always reports ready.
+func (s *Server) SetReadinessProbe(probe func() error) {
+	if probe == nil {
+		s.readiness.Store(nil)
+		return
+	}
+	s.readiness.Store(&probe)
+}
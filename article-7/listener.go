@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// unixScheme prefixes hosts that name a Unix domain socket path, as in
+// "unix:///tmp/demo.sock".
+const unixScheme = "unix://"
+
+// socketPath returns the socket path when the host uses the unix:// scheme.
+func (s *Server) socketPath() (string, bool) {
+	return strings.CutPrefix(s.host, unixScheme)
+}
+
+// network returns the network to listen on and dial.
+func (s *Server) network() string {
+	if _, ok := s.socketPath(); ok {
+		return "unix"
+	}
+	return "tcp"
+}
+
+// listen binds host:port, or the configured Unix socket. For a Unix socket,
+// a stale socket file left behind by a crashed process is removed first.
+// s.mu must be held.
+func (s *Server) listen(port int) (net.Listener, error) {
+	path, ok := s.socketPath()
+	if !ok {
+		return s.listenTCP(port)
+	}
+	if err := s.removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	return net.Listen("unix", path)
+}
+
+// listenTCP binds host:port. With a port fallback set, a port that is in use
+// makes it try the following ones.
+func (s *Server) listenTCP(port int) (net.Listener, error) {
+	ln, err := net.Listen("tcp", s.hostPort(port))
+	if s.portFallback == 0 || port == 0 || !errors.Is(err, syscall.EADDRINUSE) {
+		return ln, err
+	}
+
+	tried := []int{port}
+	for next := port + 1; next <= min(port+s.portFallback, 65535); next++ {
+		tried = append(tried, next)
+		ln, err = net.Listen("tcp", s.hostPort(next))
+		if err == nil {
+			s.logger.Printf("Server port %d is in use, falling back to %d", port, next)
+			return ln, nil
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("bind %s: ports %v are all in use: %w", s.host, tried, err)
+}
+
+// removeStaleSocket removes the socket file at path unless a server is still
+// accepting connections on it. Anything at path other than a socket is left
+// alone and reported as an error.
+func (s *Server) removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil
+	}
+	if info.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("socket %s: file exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("socket %s is already in use", path)
+	}
+	s.logger.Printf("Server removing stale socket %s", path)
+	return os.Remove(path)
+}
+
+// removeSocket deletes the socket file of a stopped Unix socket server.
+func (s *Server) removeSocket() error {
+	path, ok := s.socketPath()
+	if !ok {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// countingListener tracks the connections it hands out in active and closes
+// new ones straight away once max are open. A max of 0 means unlimited.
+type countingListener struct {
+	net.Listener
+	active *atomic.Int64
+	max    int
+	logger Logger
+
+	retired atomic.Bool // set by Restart before it closes the listener
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if n := l.active.Add(1); l.max > 0 && n > int64(l.max) {
+			l.active.Add(-1)
+			l.logger.Printf("Server reached %d connections, rejecting %s", l.max, conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		return &countedConn{Conn: conn, active: l.active}, nil
+	}
+}
+
+// countedConn gives its slot back when closed or when the server drops it
+// after an error.
+type countedConn struct {
+	net.Conn
+	active *atomic.Int64
+	once   sync.Once
+}
+
+func (c *countedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() { c.active.Add(-1) })
+	return err
+}
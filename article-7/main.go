@@ -1,54 +1,23 @@
-package main
-
-import (
-	"log"
-	"time"
-)
-
-type Server struct {
-	host    string
-	port    int
-	timeout time.Duration
-}
+// Routes such as "GET /healthz" use the Go 1.22 ServeMux patterns, which
+// builds outside a module would otherwise turn off.
+//
+//go:debug httpmuxgo121=0
 
-func (s *Server) Run() {
-	log.Printf("Server running %s:%d", s.host, s.port)
-
-}
-func (s *Server) Stop() {
-	log.Printf("Server has stopped %s:%d", s.host, s.port)
-}
+package main
 
-// NewLocalHost creates a new Server instance with optional port and timeout parameters.
-// If port or timeout are not provided (nil), default values are used.
-func NewLocalHost(port interface{}, timeout interface{}) *Server {
-	defaultPort := 8080
-	defaultTimeout := 3 * time.Second
+import "log"
 
-	// Check and set port if provided
-	actualPort := defaultPort
-	if p, ok := port.(int); ok {
-		actualPort = p
+func main() {
+	// NewServer reports invalid configuration instead of panicking
+	if _, err := NewServer("127.0.0.1", -1, 0); err != nil {
+		log.Println(err)
 	}
 
-	// Check and set timeout if provided
-	actualTimeout := defaultTimeout
-	if t, ok := timeout.(time.Duration); ok {
-		actualTimeout = t
-	}
+	// Example usage of NewLocalHost overriding only the port, keeping the default timeout
+	localHostServer := NewLocalHost(WithPort(9090))
 
-	return &Server{
-		host:    "127.0.0.1",
-		port:    actualPort,
-		timeout: actualTimeout,
+	// Serve until Ctrl+C, then shut down gracefully
+	if err := localHostServer.RunUntilSignal(); err != nil {
+		log.Fatal(err)
 	}
 }
-
-func main() {
-	// Example usage of NewLocalHost without parameters, using default values
-	localHostServer := NewLocalHost(9090, nil)
-	localHostServer.Run()
-
-	// After some operations, stop the server
-	// localHostServer.Stop()
-}
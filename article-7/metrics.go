@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ServerMetrics is a snapshot of the request counters of a Server.
+type ServerMetrics struct {
+	TotalRequests int64
+	// TotalErrors counts responses with a 5xx status.
+	TotalErrors int64
+	// Uptime is how long the server has been running, zero when stopped.
+	Uptime time.Duration
+}
+
+// Metrics returns a snapshot of the server's counters.
+func (s *Server) Metrics() ServerMetrics {
+	s.mu.Lock()
+	startedAt := s.startedAt
+	s.mu.Unlock()
+
+	m := ServerMetrics{
+		TotalRequests: s.totalRequests.Load(),
+		TotalErrors:   s.totalErrors.Load(),
+	}
+	if !startedAt.IsZero() {
+		m.Uptime = time.Since(startedAt)
+	}
+	return m
+}
+
+// countRequests updates the request counters once next has responded.
+func (s *Server) countRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		s.totalRequests.Add(1)
+		if rec.statusCode() >= http.StatusInternalServerError {
+			s.totalErrors.Add(1)
+		}
+	})
+}
+
+// statusRecorder remembers the status code and body size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// Flush forwards to the underlying writer, so streaming handlers can still
+// assert http.Flusher. It does nothing when that writer cannot flush.
+func (r *statusRecorder) Flush() {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+// Hijack forwards to the underlying writer, so websocket handlers can still
+// assert http.Hijacker.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
+// statusCode returns the status sent so far, 200 if the handler wrote nothing.
+func (r *statusRecorder) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// ServerOption configures a Server created by NewServer or NewLocalHost.
+type ServerOption func(s *Server)
+
+// WithHost sets the host the server listens on. A host of the form
+// "unix:///path/to.sock" makes it listen on a Unix domain socket instead,
+// in which case the port is ignored.
+func WithHost(host string) ServerOption {
+	return func(s *Server) { s.host = host }
+}
+
+// WithPort sets the port the server listens on. Port 0 lets the operating
+// system pick a free port on every Run; Addr reports it, and Restart keeps it.
+func WithPort(port int) ServerOption {
+	return func(s *Server) { s.port = port }
+}
+
+// WithPortFallback makes Run try up to maxTries following ports, port+1,
+// port+2 and so on, when the configured port is already in use. Addr reports
+// the port that bound; the next Run starts again from the configured one.
+func WithPortFallback(maxTries int) ServerOption {
+	return func(s *Server) { s.portFallback = maxTries }
+}
+
+// WithTimeout sets the server timeout.
+func WithTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) { s.timeout = timeout }
+}
+
+// WithReadHeaderTimeout sets how long a connection may take to send the
+// request headers, separately from the body. It defaults to the timeout.
+func WithReadHeaderTimeout(d time.Duration) ServerOption {
+	return func(s *Server) { s.readHeaderTimeout = d }
+}
+
+// WithIdleTimeout sets how long a keep-alive connection may stay idle
+// between requests. It defaults to the timeout.
+func WithIdleTimeout(d time.Duration) ServerOption {
+	return func(s *Server) { s.idleTimeout = d }
+}
+
+// WithKeepAlives turns HTTP keep-alives on or off. They are on by default.
+func WithKeepAlives(enabled bool) ServerOption {
+	return func(s *Server) { s.keepAlivesOff = !enabled }
+}
+
+// WithRecovery controls whether a panicking handler is answered with a 500
+// and logged with its stack trace, which is the default. With recovery off,
+// net/http logs the panic and drops the connection instead.
+func WithRecovery(enabled bool) ServerOption {
+	return func(s *Server) { s.recoveryOff = !enabled }
+}
+
+// WithLogger sets the logger used for lifecycle messages. It defaults to
+// log.Default().
+func WithLogger(logger Logger) ServerOption {
+	return func(s *Server) { s.logger = logger }
+}
+
+// WithTLS makes the server serve HTTPS using the given PEM encoded
+// certificate and key files. Both files are required.
+func WithTLS(certFile, keyFile string) ServerOption {
+	return func(s *Server) {
+		s.tlsCertFile = certFile
+		s.tlsKeyFile = keyFile
+	}
+}
+
+// WithH2C makes the server speak HTTP/2 without TLS, to clients that know
+// in advance that it does, next to HTTP/1 on the same port. It cannot be
+// combined with WithTLS, which negotiates HTTP/2 on its own.
+func WithH2C(enabled bool) ServerOption {
+	return func(s *Server) { s.h2c = enabled }
+}
+
+// WithMaxConnections limits how many connections may be open at once.
+// Connections beyond the limit are closed as soon as they are accepted.
+// A limit of 0, the default, means unlimited.
+func WithMaxConnections(n int) ServerOption {
+	return func(s *Server) { s.maxConns = n }
+}
+
+// WithListener makes Run serve on l instead of binding host:port itself,
+// which lets tests use an ephemeral or in-memory listener. Host and port are
+// then only used for logging. The listener is closed when the server stops.
+func WithListener(l net.Listener) ServerOption {
+	return func(s *Server) { s.listener = l }
+}
+
+// WithDrainTimeout makes Stop, and Run when its context is cancelled, wait
+// up to d instead of the timeout for active connections to finish before
+// closing them. A zero d closes them immediately.
+func WithDrainTimeout(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.drainTimeout = d
+		s.drainSet = true
+	}
+}
+
+// WithShutdownHook registers hook to run whenever the server stops, for
+// cleanup such as closing database handles. It may be given several times;
+// hooks run in reverse order of registration and their errors are returned
+// by Stop, or by Run when it shuts the server down.
+func WithShutdownHook(hook func() error) ServerOption {
+	return func(s *Server) { s.shutdownHooks = append(s.shutdownHooks, hook) }
+}
+
+// WithStaticDir serves the files in dir under urlPrefix, so with
+// WithStaticDir("/assets", "./public") a GET of /assets/app.css returns
+// ./public/app.css. Paths cannot escape dir, and missing files get a 404.
+// Run fails if dir does not exist. It may be given several times.
+func WithStaticDir(urlPrefix, dir string) ServerOption {
+	return func(s *Server) { s.static = append(s.static, staticDir{prefix: urlPrefix, dir: dir}) }
+}
+
+// WithCORS lets browsers on allowedOrigins, such as "https://example.com",
+// call the server from other sites: their requests get an
+// Access-Control-Allow-Origin header and their preflight requests a 204. An
+// origin of "*" allows every site.
+func WithCORS(allowedOrigins []string) ServerOption {
+	return func(s *Server) { s.corsOrigins = slices.Clone(allowedOrigins) }
+}
+
+// WithBaseContext makes ctx the parent of every request context, so values
+// stored in it, such as a database handle, reach all handlers. Cancelling ctx
+// shuts the server down gracefully, as cancelling the context given to Run
+// does.
+func WithBaseContext(ctx context.Context) ServerOption {
+	return func(s *Server) { s.baseCtx = ctx }
+}
+
+// WithMaxBodyBytes limits request bodies to n bytes. Larger requests get a
+// 413, and handlers reading past the limit get an error. A limit of 0, the
+// default, means unlimited.
+func WithMaxBodyBytes(n int64) ServerOption {
+	return func(s *Server) { s.maxBodyBytes = n }
+}
+
+// WithGzip compresses response bodies larger than minSize bytes for clients
+// that send "Accept-Encoding: gzip". Smaller bodies are sent uncompressed.
+func WithGzip(minSize int) ServerOption {
+	return func(s *Server) {
+		s.gzipMin = minSize
+		s.gzipOn = true
+	}
+}
+
+// WithRateLimit limits the server to requestsPerSecond on average, allowing
+// bursts of up to burst requests. Requests over the limit get a 429.
+func WithRateLimit(requestsPerSecond float64, burst int) ServerOption {
+	return func(s *Server) {
+		s.rateLimit = requestsPerSecond
+		s.rateBurst = burst
+	}
+}
+
+// WithAccessLog writes a line to the server's logger for every request, in
+// the given format, with the time the request took appended.
+func WithAccessLog(format AccessLogFormat) ServerOption {
+	return func(s *Server) { s.accessLogFormat = format }
+}
+
+// WithRequestHook registers hook to be called after every request with the
+// status code sent and how long handling took, for logging or metrics. The
+// hook cannot alter the response. It also runs, with a 500, when a handler
+// panics.
+func WithRequestHook(hook func(r *http.Request, status int, dur time.Duration)) ServerOption {
+	return func(s *Server) { s.requestHook = hook }
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ServerPool runs and stops a group of servers together.
+type ServerPool struct {
+	mu      sync.Mutex
+	servers []*Server
+}
+
+// Add adds s to the pool.
+func (p *ServerPool) Add(s *Server) error {
+	if s == nil {
+		return errors.New("add server: server must not be nil")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.servers = append(p.servers, s)
+	return nil
+}
+
+// RunAll runs every server concurrently and blocks until they have all
+// stopped. If one of them fails, the others are shut down and the failures
+// are returned combined. Cancelling ctx shuts them all down gracefully, in
+// which case RunAll returns ctx.Err().
+func (p *ServerPool) RunAll(ctx context.Context) error {
+	servers := p.snapshot()
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, s := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Run returns runCtx.Err() itself when it was stopped through
+			// runCtx, whether the caller cancelled it, its deadline passed
+			// or another server failed; only other errors are reported.
+			if err := s.Run(runCtx); err != nil && err != runCtx.Err() {
+				errs[i] = fmt.Errorf("run %s: %w", s.address(), err)
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// StopAll stops every server in the pool and returns their errors combined.
+func (p *ServerPool) StopAll() error {
+	var errs []error
+	for _, s := range p.snapshot() {
+		if err := s.Stop(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// snapshot returns a copy of the servers in the pool.
+func (p *ServerPool) snapshot() []*Server {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*Server(nil), p.servers...)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ServeTLSRedirect starts a plain HTTP listener on httpPort, next to the
+// running HTTPS server, that answers every request with a 301 to the same
+// path and query over HTTPS. The listener is closed when the server stops.
+func (s *Server) ServeTLSRedirect(httpPort int) error {
+	if !s.tlsEnabled() {
+		return errors.New("serve TLS redirect: TLS is not configured")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running {
+		return fmt.Errorf("serve TLS redirect: %w", ErrServerNotRunning)
+	}
+	if s.redirect != nil {
+		return errors.New("serve TLS redirect: already serving")
+	}
+
+	addr := net.JoinHostPort(s.host, strconv.Itoa(httpPort))
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{
+		Handler:     http.HandlerFunc(s.redirectToTLS),
+		ReadTimeout: s.timeout,
+	}
+	s.redirect = srv
+
+	go func() {
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Printf("Server TLS redirect on %s failed: %v", addr, err)
+		}
+	}()
+	s.logger.Printf("Server redirecting %s to HTTPS", addr)
+	return nil
+}
+
+// redirectToTLS redirects a request to the HTTPS port of the server.
+func (s *Server) redirectToTLS(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		// No port: an IPv6 literal keeps its brackets, which JoinHostPort
+		// would add again.
+		host = strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]")
+	}
+	s.mu.Lock()
+	port := cmp.Or(s.boundPort, s.port)
+	s.mu.Unlock()
+	if port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(port))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// closeRedirect closes the listener started by ServeTLSRedirect, if any.
+func (s *Server) closeRedirect() error {
+	s.mu.Lock()
+	srv := s.redirect
+	s.redirect = nil
+	s.mu.Unlock()
+
+	if srv == nil {
+		return nil
+	}
+	return srv.Close()
+}
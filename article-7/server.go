@@ -0,0 +1,802 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrServerRunning is returned by Run when the server is already running.
+	ErrServerRunning = errors.New("server already running")
+	// ErrServerNotRunning is returned by methods that need a running server.
+	ErrServerNotRunning = errors.New("server not running")
+)
+
+// writeTimeoutGrace is added to the timeout to get the write deadline of a
+// connection, leaving time to send the response of a request that timed out.
+const writeTimeoutGrace = time.Second
+
+// Logger is the subset of *log.Logger used by Server, so callers can plug in
+// their own logger, a no-op one or a test buffer.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+type Server struct {
+	host         string
+	port         int // as configured; see boundPort for the one in use
+	portFallback int
+	timeout      time.Duration
+
+	readHeaderTimeout time.Duration
+	idleTimeout       time.Duration
+	keepAlivesOff     bool
+	recoveryOff       bool
+
+	logger    Logger
+	healthy   atomic.Bool
+	readiness atomic.Pointer[func() error]
+
+	tlsCertFile string
+	tlsKeyFile  string
+	h2c         bool
+
+	maxConns     int
+	active       atomic.Int64
+	listener     net.Listener // caller-provided, see WithListener
+	drainTimeout time.Duration
+	drainSet     bool
+
+	shutdownHooks []func() error
+
+	rateLimit float64
+	rateBurst int
+
+	requestHook     func(r *http.Request, status int, dur time.Duration)
+	accessLogFormat AccessLogFormat
+
+	static      []staticDir
+	corsOrigins []string
+	gzipMin     int
+	gzipOn      bool
+
+	maxBodyBytes int64
+
+	baseCtx context.Context
+
+	totalRequests atomic.Int64
+	totalErrors   atomic.Int64
+
+	mu         sync.Mutex
+	mux        *http.ServeMux
+	routes     []route
+	middleware []Middleware
+	events     chan ServerState
+	running    bool
+	boundPort  int // port of the last TCP listener, 0 before the first bind
+	startedAt  time.Time
+	srv        *http.Server
+	ln         *countingListener
+	errc       chan error    // reports serve and rebind failures to Run
+	done       chan struct{} // closed when the server is stopped
+	ready      chan struct{} // closed once the listener is bound
+	redirect   *http.Server  // see ServeTLSRedirect
+}
+
+// validate reports whether the server configuration can be used to listen.
+func (s *Server) validate() error {
+	if s.host == "" {
+		return errors.New("invalid host: must not be empty")
+	}
+	if path, ok := s.socketPath(); ok && path == "" {
+		return errors.New("invalid host: unix socket path must not be empty")
+	}
+	// Port 0 asks the operating system for a free port when binding.
+	if s.port != 0 && (s.port < 1 || s.port > 65535) {
+		return fmt.Errorf("invalid port %d: must be between 1 and 65535", s.port)
+	}
+	if s.portFallback < 0 {
+		return fmt.Errorf("invalid port fallback %d: must not be negative", s.portFallback)
+	}
+	if s.timeout < 0 {
+		return fmt.Errorf("invalid timeout %s: must not be negative", s.timeout)
+	}
+	if (s.tlsCertFile == "") != (s.tlsKeyFile == "") {
+		return errors.New("invalid TLS configuration: both certificate and key files are required")
+	}
+	if s.h2c && s.tlsEnabled() {
+		return errors.New("invalid h2c configuration: cannot be combined with TLS")
+	}
+	if s.maxConns < 0 {
+		return fmt.Errorf("invalid max connections %d: must not be negative", s.maxConns)
+	}
+	if s.readHeaderTimeout < 0 {
+		return fmt.Errorf("invalid read header timeout %s: must not be negative", s.readHeaderTimeout)
+	}
+	if s.idleTimeout < 0 {
+		return fmt.Errorf("invalid idle timeout %s: must not be negative", s.idleTimeout)
+	}
+	if s.drainTimeout < 0 {
+		return fmt.Errorf("invalid drain timeout %s: must not be negative", s.drainTimeout)
+	}
+	if s.rateLimit < 0 {
+		return fmt.Errorf("invalid rate limit %g: must not be negative", s.rateLimit)
+	}
+	if s.rateLimit > 0 && s.rateBurst < 1 {
+		return fmt.Errorf("invalid rate limit burst %d: must be at least 1", s.rateBurst)
+	}
+	for i, d := range s.static {
+		if !strings.HasPrefix(d.prefix, "/") || d.pattern() == "/" {
+			return fmt.Errorf("invalid static prefix %q: must be a path below /", d.prefix)
+		}
+		for _, prev := range s.static[:i] {
+			if prev.pattern() == d.pattern() {
+				return fmt.Errorf("invalid static prefix %q: already serves %s", d.prefix, prev.dir)
+			}
+		}
+		if d.dir == "" {
+			return fmt.Errorf("invalid static directory for %s: must not be empty", d.prefix)
+		}
+	}
+	if s.maxBodyBytes < 0 {
+		return fmt.Errorf("invalid max body size %d: must not be negative", s.maxBodyBytes)
+	}
+	if s.gzipMin < 0 {
+		return fmt.Errorf("invalid gzip minimum size %d: must not be negative", s.gzipMin)
+	}
+	if s.accessLogFormat < 0 || s.accessLogFormat > CombinedLogFormat {
+		return fmt.Errorf("invalid access log format %d", s.accessLogFormat)
+	}
+	if s.logger == nil {
+		return errors.New("invalid logger: must not be nil")
+	}
+	return nil
+}
+
+// Run binds a TCP listener on host:port and serves on it until Stop is called
+// or ctx is cancelled. The configured timeout bounds reading, handling and
+// writing every request; zero means no limit. It returns an error if the
+// listener cannot be bound.
+//
+// Cancelling ctx shuts the server down gracefully, as Stop does: no new
+// connections are accepted and in-flight requests get up to the drain
+// timeout, or else timeout, to finish. Run then returns ctx.Err(), or a
+// wrapped error if that time ran out first or a shutdown hook failed.
+func (s *Server) Run(ctx context.Context) error {
+	if err := s.start(); err != nil {
+		return err
+	}
+	return s.wait(ctx)
+}
+
+// RunWithRetry is Run with retries for a listener that cannot be bound yet,
+// such as a port still held by a previous process. It makes up to attempts
+// bind attempts, treating attempts <= 0 as one, and doubles the delay between
+// them starting from backoff. It gives up early when ctx is cancelled. Only
+// an address in use or not yet available is retried; other errors, such as a
+// missing TLS key pair, are returned straight away.
+func (s *Server) RunWithRetry(ctx context.Context, attempts int, backoff time.Duration) error {
+	if attempts <= 0 {
+		attempts = 1
+	}
+
+	delay := backoff
+	for attempt := 1; ; attempt++ {
+		err := s.start()
+		if err == nil {
+			return s.wait(ctx)
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) && !errors.Is(err, syscall.EADDRNOTAVAIL) {
+			return err
+		}
+		if attempt == attempts {
+			return fmt.Errorf("bind %s failed after %d attempts: %w", s.address(), attempt, err)
+		}
+
+		s.logger.Printf("Server failed to bind %s (attempt %d of %d), retrying in %s: %v", s.address(), attempt, attempts, delay, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("bind %s gave up after %d attempts: %w", s.address(), attempt, ctx.Err())
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// start binds the listener and marks the server as running.
+func (s *Server) start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return ErrServerRunning
+	}
+	s.emitLocked(StateStarting)
+	s.boundPort = 0
+	errc := make(chan error, 1)
+	if err := s.bindLocked(errc); err != nil {
+		s.emitLocked(StateStopped)
+		return err
+	}
+	s.running = true
+	s.startedAt = time.Now()
+	s.errc = errc
+	s.done = make(chan struct{})
+	close(s.ready)
+	s.emitLocked(StateRunning)
+	s.logger.Printf("Server running %s", s.addressLocked())
+	return nil
+}
+
+// wait blocks until the server started by start is stopped, fails, or ctx
+// or the base context is cancelled, in which case it shuts the server down
+// gracefully.
+func (s *Server) wait(ctx context.Context) error {
+	s.mu.Lock()
+	errc, done := s.errc, s.done
+	s.mu.Unlock()
+
+	var baseDone <-chan struct{}
+	if s.baseCtx != nil {
+		baseDone = s.baseCtx.Done()
+	}
+
+	select {
+	case err := <-errc:
+		if srv := s.detach(); srv != nil {
+			srv.Close()
+			return s.finishStop(err)
+		}
+		return err
+	case <-done:
+		// A failed Restart stops the server and reports why.
+		select {
+		case err := <-errc:
+			return errors.Join(err, s.afterStop())
+		default:
+			return nil
+		}
+	case <-ctx.Done():
+	case <-baseDone:
+		ctx = s.baseCtx
+	}
+
+	srv := s.detach()
+	if srv == nil {
+		return nil
+	}
+	if err := s.finishStop(s.drain(srv)); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// RunUntilSignal runs the server until one of signals arrives, SIGINT and
+// SIGTERM by default, and then shuts it down gracefully. It returns any error
+// from Run or from the graceful shutdown.
+func (s *Server) RunUntilSignal(signals ...os.Signal) error {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, signals...)
+	defer signal.Stop(sigc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case sig := <-sigc:
+			s.logger.Printf("Server received %s, shutting down %s", sig, s.address())
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if err := s.Run(ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// bindLocked listens on host:port or a Unix socket, or takes the listener
+// given to WithListener, and starts serving on it in the background, over
+// HTTPS when TLS is configured. Unexpected serve errors are sent to errc.
+// s.mu must be held.
+func (s *Server) bindLocked(errc chan<- error) error {
+	if err := s.checkStaticDirs(); err != nil {
+		return err
+	}
+	srv := s.httpServer()
+	if s.tlsEnabled() {
+		cert, err := tls.LoadX509KeyPair(s.tlsCertFile, s.tlsKeyFile)
+		if err != nil {
+			return fmt.Errorf("load TLS key pair: %w", err)
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	// Restart rebinds the port in use, which may have been picked by the OS
+	// or by the port fallback.
+	port := s.port
+	if s.running {
+		port = s.boundPort
+	}
+	ln := s.listener
+	if ln == nil {
+		var err error
+		if ln, err = s.listen(port); err != nil {
+			return err
+		}
+	}
+	if addr, ok := ln.Addr().(*net.TCPAddr); ok {
+		s.boundPort = addr.Port
+	}
+	cl := &countingListener{Listener: ln, active: &s.active, max: s.maxConns, logger: s.logger}
+	s.srv = srv
+	s.ln = cl
+
+	go func() {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ServeTLS(cl, "", "")
+		} else {
+			err = srv.Serve(cl)
+		}
+		// Closing the listener directly is how Restart retires it. Any other
+		// closed listener, such as a caller's one reused after Stop, is an
+		// error.
+		if errors.Is(err, http.ErrServerClosed) || cl.retired.Load() && errors.Is(err, net.ErrClosed) {
+			return
+		}
+		select {
+		case errc <- fmt.Errorf("serve %s: %w", cl.Addr(), err):
+		default:
+		}
+	}()
+	return nil
+}
+
+// httpServer returns the http.Server for the configured handler and timeouts.
+// The header and idle timeouts default to timeout when unset. ReadTimeout
+// also bounds the TLS handshake, and WriteTimeout gets some headroom so the
+// 503 written by the timeout handler still goes out, even on the route with
+// the longest timeout. s.mu must be held.
+func (s *Server) httpServer() *http.Server {
+	srv := &http.Server{
+		Handler:           s.handler(),
+		ReadTimeout:       s.timeout,
+		ReadHeaderTimeout: cmp.Or(s.readHeaderTimeout, s.timeout),
+		IdleTimeout:       cmp.Or(s.idleTimeout, s.timeout),
+	}
+	if s.timeout > 0 {
+		longest := s.timeout
+		for _, rt := range s.routes {
+			longest = max(longest, rt.timeout)
+		}
+		srv.WriteTimeout = longest + writeTimeoutGrace
+	}
+	if s.h2c {
+		srv.Protocols = new(http.Protocols)
+		srv.Protocols.SetHTTP1(true)
+		srv.Protocols.SetUnencryptedHTTP2(true)
+	}
+	if s.baseCtx != nil {
+		srv.BaseContext = func(net.Listener) context.Context { return s.baseCtx }
+	}
+	srv.SetKeepAlivesEnabled(!s.keepAlivesOff)
+	return srv
+}
+
+// DisableKeepAlives stops reusing connections: each response is sent with
+// "Connection: close", and idle connections are closed. It takes effect
+// immediately on a running server, which helps it drain before shutdown,
+// and keeps keep-alives off for later runs.
+func (s *Server) DisableKeepAlives() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keepAlivesOff = true
+	if s.srv != nil {
+		s.srv.SetKeepAlivesEnabled(false)
+	}
+}
+
+// tlsEnabled reports whether the server serves HTTPS.
+func (s *Server) tlsEnabled() bool {
+	return s.tlsCertFile != ""
+}
+
+// IsRunning reports whether Run is currently serving.
+func (s *Server) IsRunning() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running
+}
+
+// address joins host and the port in use, or the configured one before the
+// first bind, bracketing IPv6 literals such as ::1. A Unix socket host is
+// returned as is.
+func (s *Server) address() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addressLocked()
+}
+
+// addressLocked is address with s.mu already held.
+func (s *Server) addressLocked() string {
+	if _, ok := s.socketPath(); ok {
+		return s.host
+	}
+	return s.hostPort(cmp.Or(s.boundPort, s.port))
+}
+
+// hostPort joins host and port, bracketing IPv6 literals such as ::1.
+func (s *Server) hostPort(port int) string {
+	return net.JoinHostPort(s.host, strconv.Itoa(port))
+}
+
+// WaitReady blocks until the server is accepting connections or ctx is done,
+// so callers of a Run started in another goroutine need not sleep.
+func (s *Server) WaitReady(ctx context.Context) error {
+	s.mu.Lock()
+	ready := s.ready
+	s.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// String describes the server configuration and whether it is running.
+func (s *Server) String() string {
+	s.mu.Lock()
+	port, running := s.port, s.running
+	if running {
+		port = s.boundPort
+	}
+	s.mu.Unlock()
+	return fmt.Sprintf("Server(host=%s, port=%d, timeout=%s, running=%t)", s.host, port, s.timeout, running)
+}
+
+// Clone returns a stopped copy of the server with the same configuration.
+// Runtime state such as the listener, events channel and metrics is not
+// shared, and a listener given to WithListener is not copied.
+func (s *Server) Clone() *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clone := &Server{
+		host:              s.host,
+		port:              s.port,
+		portFallback:      s.portFallback,
+		timeout:           s.timeout,
+		readHeaderTimeout: s.readHeaderTimeout,
+		idleTimeout:       s.idleTimeout,
+		keepAlivesOff:     s.keepAlivesOff,
+		recoveryOff:       s.recoveryOff,
+		logger:            s.logger,
+		tlsCertFile:       s.tlsCertFile,
+		tlsKeyFile:        s.tlsKeyFile,
+		h2c:               s.h2c,
+		maxConns:          s.maxConns,
+		drainTimeout:      s.drainTimeout,
+		drainSet:          s.drainSet,
+		shutdownHooks:     slices.Clone(s.shutdownHooks),
+		rateLimit:         s.rateLimit,
+		rateBurst:         s.rateBurst,
+		static:            slices.Clone(s.static),
+		corsOrigins:       slices.Clone(s.corsOrigins),
+		gzipMin:           s.gzipMin,
+		gzipOn:            s.gzipOn,
+		maxBodyBytes:      s.maxBodyBytes,
+		accessLogFormat:   s.accessLogFormat,
+		requestHook:       s.requestHook,
+		baseCtx:           s.baseCtx,
+		mux:               http.NewServeMux(),
+		routes:            slices.Clone(s.routes),
+		middleware:        slices.Clone(s.middleware),
+		ready:             make(chan struct{}),
+	}
+	for _, r := range clone.routes {
+		clone.mux.Handle(r.pattern, r.handler)
+	}
+	clone.healthy.Store(true)
+	return clone
+}
+
+// Addr returns the address the listener is actually bound to. It differs
+// from the configured one when the server was created with port 0.
+func (s *Server) Addr() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running {
+		return "", ErrServerNotRunning
+	}
+	return s.ln.Addr().String(), nil
+}
+
+// ActiveConnections returns the number of connections currently open.
+func (s *Server) ActiveConnections() int {
+	return int(s.active.Load())
+}
+
+// Ping dials the server and reports whether a connection could be made. The
+// dial is bounded by timeout, or by the deadline of ctx if that is earlier.
+func (s *Server) Ping(ctx context.Context) error {
+	addr, err := s.Addr()
+	if err != nil {
+		addr = s.address()
+	}
+	d := net.Dialer{Timeout: s.timeout}
+	conn, err := d.DialContext(ctx, s.network(), addr)
+	if err != nil {
+		return fmt.Errorf("ping %s: %w", addr, err)
+	}
+	return conn.Close()
+}
+
+// Restart closes the current listener and binds a new one on the same
+// host and port. Requests in flight on the old listener are drained in the
+// background, for as long as Stop would give them. If the new listener
+// cannot be bound, the server stops and both Restart and Run return the
+// bind error.
+func (s *Server) Restart() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.running {
+		return ErrServerNotRunning
+	}
+	if s.listener != nil {
+		return errors.New("restart: a listener provided with WithListener cannot be rebound")
+	}
+
+	old := s.srv
+	// The old listener must be closed before rebinding the same address.
+	s.ln.retired.Store(true)
+	if err := s.ln.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	go func() {
+		if err := s.drain(old); err != nil {
+			s.logger.Printf("Server failed to drain %s: %v", s.address(), err)
+		}
+	}()
+
+	if err := s.bindLocked(s.errc); err != nil {
+		err = fmt.Errorf("restart %s: %w", s.addressLocked(), err)
+		select {
+		case s.errc <- err:
+		default:
+		}
+		s.detachLocked()
+		s.emitLocked(StateStopped)
+		return err
+	}
+	s.logger.Printf("Server restarted %s", s.addressLocked())
+	return nil
+}
+
+// detach marks the server as stopping and returns the http.Server that was
+// serving, or nil if it was not running.
+func (s *Server) detach() *http.Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.detachLocked()
+}
+
+// detachLocked is detach with s.mu already held.
+func (s *Server) detachLocked() *http.Server {
+	if !s.running {
+		return nil
+	}
+	srv := s.srv
+	s.running = false
+	s.startedAt = time.Time{}
+	s.srv = nil
+	s.ln = nil
+	close(s.done)
+	s.ready = make(chan struct{})
+	s.emitLocked(StateStopping)
+	return srv
+}
+
+// drain stops srv for Stop, a cancelled Run and Restart alike: it waits for
+// in-flight requests for up to the drain timeout set with WithDrainTimeout,
+// or else timeout, where zero waits as long as it takes. A zero drain
+// timeout closes the connections immediately.
+func (s *Server) drain(srv *http.Server) error {
+	d := s.timeout
+	if s.drainSet {
+		if s.drainTimeout == 0 {
+			if err := srv.Close(); err != nil {
+				return fmt.Errorf("close %s: %w", s.address(), err)
+			}
+			return nil
+		}
+		d = s.drainTimeout
+	}
+
+	ctx := context.Background()
+	if d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	return s.shutdownServer(ctx, srv)
+}
+
+// shutdownServer closes the listeners of srv and waits for the active
+// connections to finish until ctx is done, then closes whatever is left.
+func (s *Server) shutdownServer(ctx context.Context, srv *http.Server) error {
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+		return fmt.Errorf("graceful shutdown of %s: %w", s.address(), err)
+	}
+	return nil
+}
+
+// Shutdown stops the server gracefully, which makes Run return nil: it stops
+// accepting connections and waits for in-flight requests to finish. When ctx
+// is done first, the remaining connections are closed and the context error
+// is returned wrapped. The shutdown hooks run afterwards. Shutting down a
+// server that is not running does nothing.
+func (s *Server) Shutdown(ctx context.Context) error {
+	srv := s.detach()
+	if srv == nil {
+		return nil
+	}
+	return s.finishStop(s.shutdownServer(ctx, srv))
+}
+
+// Close stops the server immediately, which makes Run return nil. In-flight
+// requests are cut off. The shutdown hooks run afterwards. Closing a server
+// that is not running does nothing.
+func (s *Server) Close() error {
+	srv := s.detach()
+	if srv == nil {
+		return nil
+	}
+	err := srv.Close()
+	if err != nil {
+		err = fmt.Errorf("close %s: %w", s.address(), err)
+	}
+	return s.finishStop(err)
+}
+
+// Stop shuts the server down gracefully, giving in-flight requests up to
+// timeout to finish, or the drain timeout when one was set with
+// WithDrainTimeout. A zero timeout waits as long as it takes, while a zero
+// drain timeout closes the connections immediately.
+func (s *Server) Stop() error {
+	srv := s.detach()
+	if srv == nil {
+		return nil
+	}
+	return s.finishStop(s.drain(srv))
+}
+
+// finishStop completes a stop begun with detach: it publishes StateStopped
+// and cleans up, returning err combined with any cleanup errors.
+func (s *Server) finishStop(err error) error {
+	s.emit(StateStopped)
+	if err := errors.Join(err, s.afterStop()); err != nil {
+		return err
+	}
+	s.logger.Printf("Server has stopped %s", s.address())
+	return nil
+}
+
+// afterStop cleans up once the server has stopped: it closes the TLS
+// redirect listener, removes the socket file of a Unix socket server and
+// runs the shutdown hooks.
+func (s *Server) afterStop() error {
+	return errors.Join(s.closeRedirect(), s.removeSocket(), s.runShutdownHooks())
+}
+
+// runShutdownHooks runs the hooks registered with WithShutdownHook, last
+// registered first, and returns their errors combined. A panicking hook is
+// reported as an error and does not keep the others from running.
+func (s *Server) runShutdownHooks() error {
+	var errs []error
+	for i := len(s.shutdownHooks) - 1; i >= 0; i-- {
+		errs = append(errs, runHook(s.shutdownHooks[i]))
+	}
+	return errors.Join(errs...)
+}
+
+// runHook calls hook, turning a panic into an error.
+func runHook(hook func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("shutdown hook panicked: %v", r)
+		}
+	}()
+	return hook()
+}
+
+// NewServer creates a new Server listening on host:port, applies opts and
+// validates the resulting configuration.
+func NewServer(host string, port int, timeout time.Duration, opts ...ServerOption) (*Server, error) {
+	server := &Server{
+		host:    host,
+		port:    port,
+		timeout: timeout,
+		logger:  log.Default(),
+		mux:     http.NewServeMux(),
+		ready:   make(chan struct{}),
+	}
+
+	server.healthy.Store(true)
+
+	for _, opt := range opts {
+		opt(server)
+	}
+	if err := server.validate(); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// Defaults used by NewLocalHost.
+const (
+	DefaultHost    = "127.0.0.1"
+	DefaultPort    = 8080
+	DefaultTimeout = 3 * time.Second
+)
+
+// NewLocalHost creates a new Server bound to DefaultHost:DefaultPort with
+// DefaultTimeout. Any of the defaults can be overridden by passing options
+// such as WithPort. It panics if the options produce an invalid
+// configuration; use NewServer to handle the error instead.
+func NewLocalHost(opts ...ServerOption) *Server {
+	server, err := NewServer(DefaultHost, DefaultPort, DefaultTimeout, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return server
+}
+
+// NewLocalHostWithDefaults creates a new Server with every default applied:
+// 127.0.0.1:8080 with a 3s timeout.
+func NewLocalHostWithDefaults() *Server {
+	return NewLocalHost()
+}
+
+// NewLocalHostLegacy creates a new Server instance with optional port and timeout parameters.
+// If port or timeout are not provided (nil), default values are used.
+//
+// Deprecated: values of the wrong type are silently ignored; use NewLocalHost
+// with WithPort and WithTimeout instead.
+func NewLocalHostLegacy(port interface{}, timeout interface{}) *Server {
+	var opts []ServerOption
+
+	// Check and set port if provided
+	if p, ok := port.(int); ok {
+		opts = append(opts, WithPort(p))
+	}
+
+	// Check and set timeout if provided
+	if t, ok := timeout.(time.Duration); ok {
+		opts = append(opts, WithTimeout(t))
+	}
+
+	return NewLocalHost(opts...)
+}
@@ -0,0 +1,2383 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// discard is a logger for tests that do not look at the server's output.
+var discard = log.New(io.Discard, "", 0)
+
+// logBuffer is a Logger that keeps what the server logs, for tests to
+// inspect while it runs.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *logBuffer) Printf(format string, args ...interface{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fmt.Fprintf(&b.buf, format+"\n", args...)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newTestServer creates a server on an OS-assigned loopback port with a
+// short timeout, applying opts on top.
+func newTestServer(t *testing.T, opts ...ServerOption) *Server {
+	t.Helper()
+	s, err := NewServer("127.0.0.1", 0, time.Second, append([]ServerOption{WithLogger(discard)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// startServer runs s in the background and returns once it is running. The
+// returned channel receives the result of Run. Whatever is still running
+// when the test ends is closed.
+func startServer(t *testing.T, s *Server) <-chan error {
+	t.Helper()
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(context.Background()) }()
+	t.Cleanup(func() { s.Close() })
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.IsRunning() {
+		select {
+		case err := <-errc:
+			t.Fatalf("Run: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return errc
+}
+
+// serve runs s until the test ends, when it checks that Stop and Run both
+// succeed, and returns the address it listens on.
+func serve(t *testing.T, s *Server) string {
+	t.Helper()
+	errc := startServer(t, s)
+	t.Cleanup(func() {
+		if err := s.Stop(); err != nil {
+			t.Errorf("Stop: %v", err)
+		}
+		if err := <-errc; err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	})
+	addr, err := s.Addr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+// get fetches url and returns the status code and body of the response.
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+// stopped waits for the result of Run after the server was told to stop.
+func stopped(t *testing.T, errc <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return")
+		return nil
+	}
+}
+
+func TestNewServerValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		host    string
+		port    int
+		timeout time.Duration
+		want    string
+	}{
+		{"negative port", "127.0.0.1", -1, 0, "invalid port -1: must be between 1 and 65535"},
+		{"port too large", "127.0.0.1", 65536, 0, "invalid port 65536: must be between 1 and 65535"},
+		{"empty host", "", 8080, 0, "invalid host: must not be empty"},
+		{"negative timeout", "127.0.0.1", 8080, -time.Second, "invalid timeout -1s: must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewServer(tt.host, tt.port, tt.timeout)
+			if err == nil {
+				t.Fatalf("NewServer(%q, %d, %s) succeeded, want error", tt.host, tt.port, tt.timeout)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("NewServer(%q, %d, %s) error = %q, want %q", tt.host, tt.port, tt.timeout, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	s, err := NewServer("127.0.0.1", 9090, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.host != "127.0.0.1" || s.port != 9090 || s.timeout != time.Second {
+		t.Errorf("NewServer = %s, want 127.0.0.1:9090 with a 1s timeout", s)
+	}
+}
+
+func TestNewLocalHostPanicsOnInvalidOptions(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("NewLocalHost(WithPort(-1)) did not panic")
+		}
+	}()
+	NewLocalHost(WithPort(-1))
+}
+
+func TestRunBlocksUntilStop(t *testing.T) {
+	s := newTestServer(t)
+	errc := startServer(t, s)
+	select {
+	case err := <-errc:
+		t.Fatalf("Run returned before Stop: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	addr, err := s.Addr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stopped(t, errc); err != nil {
+		t.Errorf("Run = %v, want nil after Stop", err)
+	}
+}
+
+func TestRunBindError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	s := newTestServer(t, WithPort(ln.Addr().(*net.TCPAddr).Port))
+	if err := s.Run(context.Background()); !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("Run on a port in use = %v, want EADDRINUSE", err)
+	}
+	if s.IsRunning() {
+		t.Error("server is running after a failed bind")
+	}
+}
+
+func TestRunReturnsWhenContextCancelled(t *testing.T) {
+	s := newTestServer(t, WithTimeout(500*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx) }()
+	if err := s.WaitReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run = %v, want context.Canceled", err)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Run did not return within the timeout")
+	}
+}
+
+func TestRunCancelledWithRequestInFlight(t *testing.T) {
+	s := newTestServer(t, WithTimeout(100*time.Millisecond))
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	// The route outlives the server timeout, which bounds the shutdown.
+	err := s.HandleFuncTimeout("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx) }()
+	if err := s.WaitReady(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := s.Addr()
+	go http.Get("http://" + addr + "/slow")
+	<-started
+
+	cancel()
+	if err := stopped(t, errc); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run = %v, want a wrapped context.DeadlineExceeded", err)
+	}
+}
+
+func TestIsRunning(t *testing.T) {
+	s := newTestServer(t)
+	if s.IsRunning() {
+		t.Fatal("IsRunning = true before Run")
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(context.Background()) }()
+	// Poll from another goroutine too, so -race sees the concurrent reads.
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		for !s.IsRunning() {
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	select {
+	case <-polled:
+	case err := <-errc:
+		t.Fatalf("Run: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("IsRunning never became true")
+	}
+
+	if err := s.Run(context.Background()); !errors.Is(err, ErrServerRunning) {
+		t.Errorf("second Run = %v, want ErrServerRunning", err)
+	}
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stopped(t, errc); err != nil {
+		t.Fatal(err)
+	}
+	if s.IsRunning() {
+		t.Error("IsRunning = true after Stop")
+	}
+}
+
+func TestRestart(t *testing.T) {
+	s := newTestServer(t)
+	addr := serve(t, s)
+
+	for i := 0; i < 3; i++ {
+		if err := s.Restart(); err != nil {
+			t.Fatalf("Restart %d: %v", i+1, err)
+		}
+		if got, _ := s.Addr(); got != addr {
+			t.Errorf("Restart %d moved the server from %s to %s", i+1, addr, got)
+		}
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("server unreachable after restart %d: %v", i+1, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestRestartNotRunning(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.Restart(); !errors.Is(err, ErrServerNotRunning) {
+		t.Errorf("Restart = %v, want ErrServerNotRunning", err)
+	}
+}
+
+func TestRunFailsWhenListenerIsClosed(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+
+	// Only a listener retired by Restart may close without an error.
+	s := newTestServer(t, WithListener(ln))
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(context.Background()) }()
+	if err := stopped(t, errc); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Run on a closed listener = %v, want net.ErrClosed", err)
+	}
+	if s.IsRunning() {
+		t.Error("server is running after its listener failed")
+	}
+}
+
+func TestAddr(t *testing.T) {
+	s := newTestServer(t)
+	if _, err := s.Addr(); !errors.Is(err, ErrServerNotRunning) {
+		t.Errorf("Addr before Run = %v, want ErrServerNotRunning", err)
+	}
+
+	addr := serve(t, s)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "127.0.0.1" {
+		t.Errorf("Addr host = %s, want 127.0.0.1", host)
+	}
+	if p, _ := strconv.Atoi(port); p <= 0 {
+		t.Errorf("Addr = %s, want an OS-assigned port", addr)
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	s := newTestServer(t, WithLogger(log.New(&buf, "", 0)))
+	errc := startServer(t, s)
+	addr, _ := s.Addr()
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stopped(t, errc); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "Server running " + addr + "\n"; !strings.Contains(buf.String(), want) {
+		t.Errorf("log = %q, want a line %q", buf.String(), want)
+	}
+}
+
+func TestAddressFormatting(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"127.0.0.1", "127.0.0.1:8080"},
+		{"::1", "[::1]:8080"},
+		{"localhost", "localhost:8080"},
+	}
+	for _, tt := range tests {
+		s := NewLocalHost(WithHost(tt.host), WithLogger(discard))
+		got := s.address()
+		if got != tt.want {
+			t.Errorf("address with host %q = %q, want %q", tt.host, got, tt.want)
+		}
+		if host, _, err := net.SplitHostPort(got); err != nil || host != tt.host {
+			t.Errorf("SplitHostPort(%q) = %q, %v, want host %q", got, host, err, tt.host)
+		}
+	}
+}
+
+func TestRunIPv6(t *testing.T) {
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("no IPv6 loopback: %v", err)
+	}
+	ln.Close()
+
+	addr := serve(t, newTestServer(t, WithHost("::1")))
+	if !strings.HasPrefix(addr, "[::1]:") {
+		t.Errorf("Addr = %s, want a bracketed [::1] address", addr)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	s := newTestServer(t)
+	ts := httptest.NewServer(s.handler())
+	defer ts.Close()
+
+	if code, body := get(t, ts.URL+"/healthz"); code != http.StatusOK || body != "ok" {
+		t.Errorf("GET /healthz = %d %q, want 200 \"ok\"", code, body)
+	}
+	s.SetHealthy(false)
+	if code, _ := get(t, ts.URL+"/healthz"); code != http.StatusServiceUnavailable {
+		t.Errorf("GET /healthz when unhealthy = %d, want 503", code)
+	}
+	s.SetHealthy(true)
+	if code, _ := get(t, ts.URL+"/healthz"); code != http.StatusOK {
+		t.Errorf("GET /healthz when healthy again = %d, want 200", code)
+	}
+	if code, _ := get(t, ts.URL+"/healthz/extra"); code != http.StatusNotFound {
+		t.Errorf("GET /healthz/extra = %d, want 404", code)
+	}
+}
+
+func TestHealthzConcurrentToggle(t *testing.T) {
+	s := newTestServer(t)
+	addr := serve(t, s)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			s.SetHealthy(i%2 == 0)
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		if code, _ := get(t, "http://"+addr+"/healthz"); code != http.StatusOK && code != http.StatusServiceUnavailable {
+			t.Errorf("GET /healthz = %d, want 200 or 503", code)
+		}
+	}
+	<-done
+}
+
+func TestUseOrder(t *testing.T) {
+	s := newTestServer(t)
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	record := func(name string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	if err := s.Use(record("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Use(record("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := serve(t, s)
+	get(t, "http://"+addr+"/healthz")
+	mu.Lock()
+	got := strings.Join(order, ",")
+	mu.Unlock()
+	if got != "first,second" {
+		t.Errorf("middleware ran in order %s, want first,second", got)
+	}
+
+	if err := s.Use(record("late")); !errors.Is(err, ErrServerRunning) {
+		t.Errorf("Use while running = %v, want ErrServerRunning", err)
+	}
+}
+
+// selfSignedCert writes a self-signed certificate for 127.0.0.1 and its key
+// to a temporary directory, and returns their paths with a client that
+// trusts the certificate.
+func selfSignedCert(t *testing.T) (certFile, keyFile string, client *http.Client) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	transport := &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	t.Cleanup(transport.CloseIdleConnections)
+	return certFile, keyFile, &http.Client{Transport: transport}
+}
+
+func TestWithTLS(t *testing.T) {
+	certFile, keyFile, client := selfSignedCert(t)
+	addr := serve(t, newTestServer(t, WithTLS(certFile, keyFile)))
+
+	resp, err := client.Get("https://" + addr + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+		t.Errorf("GET https://%s/healthz = %d, TLS %v, want 200 over TLS", addr, resp.StatusCode, resp.TLS != nil)
+	}
+}
+
+func TestWithTLSRequiresBothFiles(t *testing.T) {
+	certFile, keyFile, _ := selfSignedCert(t)
+	for _, opt := range []ServerOption{WithTLS(certFile, ""), WithTLS("", keyFile)} {
+		_, err := NewServer("127.0.0.1", 0, time.Second, opt)
+		if err == nil || !strings.Contains(err.Error(), "both certificate and key files are required") {
+			t.Errorf("NewServer with half a key pair = %v, want an error asking for both files", err)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	s := newTestServer(t)
+	serve(t, s)
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Ping of a running server = %v, want nil", err)
+	}
+}
+
+func TestPingRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	s := newTestServer(t, WithPort(port))
+	err = s.Ping(context.Background())
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || !errors.Is(err, syscall.ECONNREFUSED) {
+		t.Errorf("Ping of a closed port = %v, want a wrapped dial error", err)
+	}
+}
+
+func TestPingContextDeadline(t *testing.T) {
+	s := newTestServer(t, WithTimeout(time.Hour))
+	serve(t, s)
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	if err := s.Ping(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Ping with an expired context = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	s := newTestServer(t)
+	events := s.Events()
+	errc := startServer(t, s)
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stopped(t, errc); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []ServerState{StateStarting, StateRunning, StateStopping, StateStopped} {
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("event = %s, want %s", got, want)
+			}
+		default:
+			t.Fatalf("missing %s event", want)
+		}
+	}
+}
+
+func TestEventsDropWhenFull(t *testing.T) {
+	s := newTestServer(t)
+	s.Events() // never read
+	for i := 0; i < eventBuffer; i++ {
+		errc := startServer(t, s)
+		if err := s.Stop(); err != nil {
+			t.Fatal(err)
+		}
+		if err := stopped(t, errc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(s.Events()); got != eventBuffer {
+		t.Errorf("%d buffered events, want the buffer of %d full", got, eventBuffer)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWithMaxConnections(t *testing.T) {
+	const n = 2
+	s := newTestServer(t, WithMaxConnections(n), WithTimeout(0))
+	addr := serve(t, s)
+
+	var conns []net.Conn
+	for i := 0; i < n; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	waitFor(t, "the connections to be counted", func() bool { return s.ActiveConnections() == n })
+
+	extra, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer extra.Close()
+	extra.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := extra.Read(make([]byte, 1)); err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("read from connection %d = %v, want it closed by the server", n+1, err)
+	}
+	if got := s.ActiveConnections(); got != n {
+		t.Errorf("ActiveConnections = %d after a rejected connection, want %d", got, n)
+	}
+
+	conns[0].Close()
+	waitFor(t, "a closed connection to be released", func() bool { return s.ActiveConnections() == n-1 })
+}
+
+// pipeListener is an in-memory net.Listener whose connections are made by
+// Dial with net.Pipe.
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr { return pipeAddr{} }
+
+// Dial connects to the listener, for use as an http.Transport DialContext.
+func (l *pipeListener) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+func TestWithListener(t *testing.T) {
+	// The listener wins over a port that could not be bound.
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	ln := newPipeListener()
+	s := newTestServer(t, WithListener(ln), WithPort(busy.Addr().(*net.TCPAddr).Port))
+	if err := s.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("world"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if addr := serve(t, s); addr != "pipe" {
+		t.Errorf("Addr = %s, want the listener's address", addr)
+	}
+
+	client := &http.Client{Transport: &http.Transport{DialContext: ln.Dial}}
+	defer client.CloseIdleConnections()
+	resp, err := client.Get("http://pipe/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "world" {
+		t.Errorf("GET /hello over the pipe = %d %q, want 200 \"world\"", resp.StatusCode, body)
+	}
+}
+
+func TestRunUntilSignal(t *testing.T) {
+	s := newTestServer(t)
+	// Running twice checks that the first call let go of the signal.
+	for i := 0; i < 2; i++ {
+		errc := make(chan error, 1)
+		go func() { errc <- s.RunUntilSignal(syscall.SIGUSR1) }()
+		waitFor(t, "the server to run", s.IsRunning)
+
+		if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+			t.Fatal(err)
+		}
+		if err := stopped(t, errc); err != nil {
+			t.Errorf("RunUntilSignal = %v, want nil after a clean shutdown", err)
+		}
+		if s.IsRunning() {
+			t.Error("server still running after the signal")
+		}
+	}
+}
+
+// sleepHandler answers after d, or gives up when the request is cancelled.
+func sleepHandler(d time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(d):
+			w.Write([]byte("done"))
+		case <-r.Context().Done():
+		}
+	}
+}
+
+func TestTimeoutAnswers503(t *testing.T) {
+	s := newTestServer(t, WithTimeout(100*time.Millisecond))
+	if err := s.HandleFunc("/slow", sleepHandler(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, s)
+
+	start := time.Now()
+	if code, _ := get(t, "http://"+addr+"/slow"); code != http.StatusServiceUnavailable {
+		t.Errorf("GET /slow = %d, want 503", code)
+	}
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("GET /slow took %s, want it cut off after the timeout", elapsed)
+	}
+}
+
+func TestZeroTimeoutMeansNoLimit(t *testing.T) {
+	s := newTestServer(t, WithTimeout(0))
+	if err := s.HandleFunc("/slow", sleepHandler(100*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	srv := s.httpServer()
+	if srv.ReadTimeout != 0 || srv.WriteTimeout != 0 {
+		t.Errorf("ReadTimeout, WriteTimeout = %s, %s, want no limits", srv.ReadTimeout, srv.WriteTimeout)
+	}
+
+	addr := serve(t, s)
+	if code, body := get(t, "http://"+addr+"/slow"); code != http.StatusOK || body != "done" {
+		t.Errorf("GET /slow = %d %q, want 200 \"done\"", code, body)
+	}
+}
+
+func TestTimeoutSetsServerTimeouts(t *testing.T) {
+	srv := newTestServer(t, WithTimeout(2*time.Second)).httpServer()
+	if srv.ReadTimeout != 2*time.Second {
+		t.Errorf("ReadTimeout = %s, want 2s", srv.ReadTimeout)
+	}
+	if srv.WriteTimeout != 2*time.Second+writeTimeoutGrace {
+		t.Errorf("WriteTimeout = %s, want the timeout plus %s", srv.WriteTimeout, writeTimeoutGrace)
+	}
+}
+
+func TestNewLocalHostWithDefaults(t *testing.T) {
+	s := NewLocalHostWithDefaults()
+	if s.host != "127.0.0.1" || s.port != 8080 || s.timeout != 3*time.Second {
+		t.Errorf("NewLocalHostWithDefaults() = %s, want 127.0.0.1:8080 with a 3s timeout", s)
+	}
+	if s.host != DefaultHost || s.port != DefaultPort || s.timeout != DefaultTimeout {
+		t.Errorf("NewLocalHostWithDefaults() = %s, want the Default constants", s)
+	}
+	if legacy := NewLocalHostLegacy(nil, nil); legacy.String() != s.String() {
+		t.Errorf("NewLocalHostLegacy(nil, nil) = %s, want %s", legacy, s)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	orig := newTestServer(t, WithHost("localhost"), WithPort(9090), WithTimeout(1500*time.Millisecond))
+	data, err := json.Marshal(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"host":"localhost","port":9090,"timeout":"1.5s"}`; string(data) != want {
+		t.Errorf("json.Marshal = %s, want %s", data, want)
+	}
+
+	got, err := LoadServerFromJSON(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.host != orig.host || got.port != orig.port || got.timeout != orig.timeout {
+		t.Errorf("round trip = %s, want %s", got, orig)
+	}
+}
+
+func TestLoadServerFromJSON(t *testing.T) {
+	s, err := LoadServerFromJSON(strings.NewReader(`{"port": 9090}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.host != DefaultHost || s.port != 9090 || s.timeout != DefaultTimeout {
+		t.Errorf("missing fields gave %s, want the defaults", s)
+	}
+
+	for _, config := range []string{
+		`{"port": 9090, "debug": true}`,
+		`{"timeout": "soon"}`,
+		`{"port": -1}`,
+		`not json`,
+	} {
+		if _, err := LoadServerFromJSON(strings.NewReader(config)); err == nil {
+			t.Errorf("LoadServerFromJSON(%s) succeeded, want an error", config)
+		}
+	}
+}
+
+// unsetenv unsets key for the rest of the test.
+func unsetenv(t *testing.T, key string) {
+	t.Setenv(key, "") // restores the variable afterwards
+	os.Unsetenv(key)
+}
+
+func TestNewServerFromEnv(t *testing.T) {
+	t.Run("all set", func(t *testing.T) {
+		t.Setenv("SERVER_HOST", "localhost")
+		t.Setenv("SERVER_PORT", "9090")
+		t.Setenv("SERVER_TIMEOUT", "1m")
+		s, err := NewServerFromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.host != "localhost" || s.port != 9090 || s.timeout != time.Minute {
+			t.Errorf("NewServerFromEnv() = %s, want localhost:9090 with a 1m timeout", s)
+		}
+	})
+
+	t.Run("partially set", func(t *testing.T) {
+		unsetenv(t, "SERVER_HOST")
+		t.Setenv("SERVER_PORT", "9090")
+		unsetenv(t, "SERVER_TIMEOUT")
+		s, err := NewServerFromEnv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.host != DefaultHost || s.port != 9090 || s.timeout != DefaultTimeout {
+			t.Errorf("NewServerFromEnv() = %s, want the defaults except port 9090", s)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := []struct {
+			key, value, want string
+		}{
+			{"SERVER_PORT", "abc", `invalid SERVER_PORT "abc"`},
+			{"SERVER_TIMEOUT", "5", `invalid SERVER_TIMEOUT "5"`},
+			{"SERVER_PORT", "70000", "invalid port 70000"},
+		}
+		for _, tt := range tests {
+			unsetenv(t, "SERVER_PORT")
+			unsetenv(t, "SERVER_TIMEOUT")
+			t.Setenv(tt.key, tt.value)
+			_, err := NewServerFromEnv()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewServerFromEnv() with %s=%s = %v, want an error containing %q", tt.key, tt.value, err, tt.want)
+			}
+		}
+	})
+}
+
+// holdPort listens on a free loopback port until the returned listener is
+// closed.
+func holdPort(t *testing.T) (net.Listener, int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return ln, ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestRunWithRetry(t *testing.T) {
+	held, port := holdPort(t)
+	s := newTestServer(t, WithPort(port))
+	errc := make(chan error, 1)
+	go func() { errc <- s.RunWithRetry(context.Background(), 10, 20*time.Millisecond) }()
+
+	time.Sleep(50 * time.Millisecond) // let a few attempts fail
+	if s.IsRunning() {
+		t.Fatal("server bound a port that is in use")
+	}
+	held.Close()
+	waitFor(t, "the retry to bind", s.IsRunning)
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stopped(t, errc); err != nil {
+		t.Errorf("RunWithRetry = %v, want nil after Stop", err)
+	}
+}
+
+func TestRunWithRetryGivesUp(t *testing.T) {
+	_, port := holdPort(t)
+	s := newTestServer(t, WithPort(port))
+
+	// attempts <= 0 is a single attempt.
+	err := s.RunWithRetry(context.Background(), 0, time.Hour)
+	if !errors.Is(err, syscall.EADDRINUSE) || !strings.Contains(err.Error(), "after 1 attempts") {
+		t.Errorf("RunWithRetry(0 attempts) = %v, want EADDRINUSE after 1 attempt", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.RunWithRetry(ctx, 100, time.Hour); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("RunWithRetry with an expiring context = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestRunWithRetryOnlyRetriesBindErrors(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestServer(t, WithTLS(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")))
+
+	start := time.Now()
+	err := s.RunWithRetry(context.Background(), 5, time.Hour)
+	if !errors.Is(err, os.ErrNotExist) || strings.Contains(err.Error(), "attempts") {
+		t.Errorf("RunWithRetry with missing TLS files = %v, want the load error without retries", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("RunWithRetry waited before returning a TLS error")
+	}
+}
+
+// startRequest sends a GET of url in the background and returns once the
+// handler serving it closes started. The returned channel receives nil for a
+// 200 response and an error otherwise.
+func startRequest(t *testing.T, url string, started <-chan struct{}) <-chan error {
+	t.Helper()
+	result := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err == nil && resp.StatusCode != http.StatusOK {
+				err = fmt.Errorf("status %d", resp.StatusCode)
+			}
+		}
+		result <- err
+	}()
+	select {
+	case <-started:
+	case err := <-result:
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	return result
+}
+
+func TestStopDrainsInFlightRequests(t *testing.T) {
+	s := newTestServer(t, WithDrainTimeout(2*time.Second))
+	started := make(chan struct{})
+	if err := s.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	errc := startServer(t, s)
+	addr, _ := s.Addr()
+	result := startRequest(t, "http://"+addr+"/slow", started)
+
+	start := time.Now()
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Stop returned after %s, before the request finished", elapsed)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("in-flight request failed: %v", err)
+	}
+	if err := stopped(t, errc); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestZeroDrainTimeoutClosesImmediately(t *testing.T) {
+	s := newTestServer(t, WithDrainTimeout(0))
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	if err := s.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}); err != nil {
+		t.Fatal(err)
+	}
+	startServer(t, s)
+	addr, _ := s.Addr()
+	result := startRequest(t, "http://"+addr+"/slow", started)
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err == nil {
+		t.Error("in-flight request succeeded, want its connection closed")
+	}
+}
+
+func TestDrainTimeoutAppliesWhenRunIsCancelled(t *testing.T) {
+	s := newTestServer(t, WithTimeout(time.Hour), WithDrainTimeout(50*time.Millisecond))
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	if err := s.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(ctx) }()
+	waitFor(t, "the server to run", s.IsRunning)
+	addr, _ := s.Addr()
+	startRequest(t, "http://"+addr+"/slow", started)
+
+	cancel()
+	select {
+	case err := <-errc:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Run = %v, want the drain to run out", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run ignored the drain timeout")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.HandleFunc("/fail", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	errc := startServer(t, s)
+	addr, _ := s.Addr()
+
+	const ok, failed = 5, 3
+	for i := 0; i < ok; i++ {
+		get(t, "http://"+addr+"/ok")
+	}
+	for i := 0; i < failed; i++ {
+		get(t, "http://"+addr+"/fail")
+	}
+	get(t, "http://"+addr+"/missing") // a 404 is not a server error
+
+	// The counters are updated once the response has gone out.
+	waitFor(t, "the requests to be counted", func() bool { return s.Metrics().TotalRequests == ok+failed+1 })
+	m := s.Metrics()
+	if m.TotalRequests != ok+failed+1 || m.TotalErrors != failed {
+		t.Errorf("Metrics = %+v, want %d requests and %d errors", m, ok+failed+1, failed)
+	}
+	if m.Uptime <= 0 {
+		t.Errorf("Uptime = %s while running, want it positive", m.Uptime)
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stopped(t, errc); err != nil {
+		t.Fatal(err)
+	}
+	if m := s.Metrics(); m.Uptime != 0 {
+		t.Errorf("Uptime = %s after Stop, want 0", m.Uptime)
+	}
+}
+
+func TestWrappedWritersKeepFlushAndHijack(t *testing.T) {
+	// Every wrapping middleware is on. The timeout is off, since
+	// http.TimeoutHandler can neither flush nor hijack.
+	s := newTestServer(t, WithTimeout(0), WithGzip(0), WithMaxBodyBytes(1<<20),
+		WithAccessLog(CommonLogFormat), WithRequestHook(func(*http.Request, int, time.Duration) {}))
+	if err := s.HandleFunc("/raw", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Error("ResponseWriter is not an http.Flusher")
+		}
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			t.Error("ResponseWriter is not an http.Hijacker")
+			return
+		}
+		conn, rw, err := hj.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		rw.Flush()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, s)
+
+	req, _ := http.NewRequest("GET", "http://"+addr+"/raw", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "hijacked" {
+		t.Errorf("body = %q, want the one written to the hijacked connection", body)
+	}
+}
+
+func TestString(t *testing.T) {
+	s := NewLocalHost(WithLogger(discard))
+	if got, want := s.String(), "Server(host=127.0.0.1, port=8080, timeout=3s, running=false)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got := fmt.Sprint(s); got != s.String() {
+		t.Errorf("fmt.Sprint = %q, want String()", got)
+	}
+
+	s = newTestServer(t)
+	addr := serve(t, s)
+	_, port, _ := net.SplitHostPort(addr)
+	if got, want := s.String(), "Server(host=127.0.0.1, port="+port+", timeout=1s, running=true)"; got != want {
+		t.Errorf("String() while running = %q, want %q", got, want)
+	}
+}
+
+func TestPortZeroResolves(t *testing.T) {
+	s := newTestServer(t, WithPort(0))
+	addr := serve(t, s)
+	_, p, _ := net.SplitHostPort(addr)
+	if port, _ := strconv.Atoi(p); port <= 1024 {
+		t.Errorf("Addr = %s, want an ephemeral port above 1024", addr)
+	}
+	if !strings.Contains(s.String(), "port="+p) {
+		t.Errorf("String() = %s, want the bound port %s", s, p)
+	}
+	if s.port != 0 {
+		t.Errorf("configured port = %d after Run, want it left at 0", s.port)
+	}
+
+	// A clone binds a port of its own rather than the one in use.
+	clone := s.Clone()
+	if cloneAddr := serve(t, clone); cloneAddr == addr {
+		t.Errorf("clone bound %s, the address of the original", cloneAddr)
+	}
+}
+
+func TestClone(t *testing.T) {
+	s := newTestServer(t, WithPort(0), WithTimeout(2*time.Second))
+	if err := s.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("world"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	serve(t, s)
+
+	clone := s.Clone()
+	if clone.IsRunning() {
+		t.Error("clone of a running server is running")
+	}
+	if clone.host != s.host || clone.timeout != s.timeout {
+		t.Errorf("Clone() = %s, want the configuration of %s", clone, s)
+	}
+
+	WithPort(9999)(clone)
+	if s.port != 0 {
+		t.Errorf("changing the clone's port changed the original's to %d", s.port)
+	}
+
+	// The clone serves the same routes on its own listener.
+	WithPort(0)(clone)
+	addr := serve(t, clone)
+	if code, body := get(t, "http://"+addr+"/hello"); code != http.StatusOK || body != "world" {
+		t.Errorf("GET /hello on the clone = %d %q, want 200 \"world\"", code, body)
+	}
+	if !s.IsRunning() {
+		t.Error("running the clone stopped the original")
+	}
+}
+
+func TestHandle(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("world"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, s)
+
+	if code, body := get(t, "http://"+addr+"/hello"); code != http.StatusOK || body != "world" {
+		t.Errorf("GET /hello = %d %q, want 200 \"world\"", code, body)
+	}
+	if code, body := get(t, "http://"+addr+"/healthz"); code != http.StatusOK || body != "ok" {
+		t.Errorf("GET /healthz = %d %q, want 200 \"ok\"", code, body)
+	}
+
+	err := s.Handle("/late", http.NotFoundHandler())
+	if !errors.Is(err, ErrServerRunning) {
+		t.Errorf("Handle while running = %v, want ErrServerRunning", err)
+	}
+}
+
+func TestHandleBadRoutes(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.Handle("/taken", http.NotFoundHandler()); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		pattern string
+		handler http.Handler
+	}{
+		{"invalid pattern", "GET", http.NotFoundHandler()},
+		{"conflict", "/taken", http.NotFoundHandler()},
+		{"nil handler", "/nil", nil},
+	}
+	for _, tt := range tests {
+		err := s.Handle(tt.pattern, tt.handler)
+		if err == nil || !strings.HasPrefix(err.Error(), "handle "+tt.pattern+": ") {
+			t.Errorf("%s: Handle(%q) = %v, want an error", tt.name, tt.pattern, err)
+		}
+	}
+	if err := s.HandleFunc("/nil", nil); err == nil {
+		t.Error("HandleFunc with a nil func succeeded, want an error")
+	}
+}
+
+func TestServerPool(t *testing.T) {
+	var pool ServerPool
+	if err := pool.Add(nil); err == nil {
+		t.Error("Add(nil) succeeded, want an error")
+	}
+	servers := []*Server{newTestServer(t), newTestServer(t), newTestServer(t)}
+	for _, s := range servers {
+		if err := pool.Add(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- pool.RunAll(context.Background()) }()
+	for i, s := range servers {
+		waitFor(t, fmt.Sprintf("server %d to run", i), s.IsRunning)
+	}
+
+	if err := pool.StopAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stopped(t, errc); err != nil {
+		t.Errorf("RunAll = %v, want nil after StopAll", err)
+	}
+	for i, s := range servers {
+		if s.IsRunning() {
+			t.Errorf("server %d still running after StopAll", i)
+		}
+	}
+}
+
+func TestServerPoolBindFailure(t *testing.T) {
+	_, port := holdPort(t)
+	var pool ServerPool
+	ok, busy := newTestServer(t), newTestServer(t, WithPort(port))
+	pool.Add(ok)
+	pool.Add(busy)
+
+	err := pool.RunAll(context.Background())
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("RunAll = %v, want the bind error", err)
+	}
+	if ok.IsRunning() {
+		t.Error("the other server kept running after a bind failure")
+	}
+}
+
+func TestServerPoolDeadline(t *testing.T) {
+	var pool ServerPool
+	pool.Add(newTestServer(t))
+	pool.Add(newTestServer(t))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := pool.RunAll(ctx); err != context.DeadlineExceeded {
+		t.Errorf("RunAll = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	s := newTestServer(t)
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run(context.Background()) }()
+	defer func() {
+		s.Stop()
+		<-errc
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.WaitReady(ctx); err != nil {
+		t.Fatalf("WaitReady = %v, want nil once Run binds", err)
+	}
+	addr, err := s.Addr()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial right after WaitReady: %v", err)
+	}
+	conn.Close()
+}
+
+func TestWaitReadyWithoutRun(t *testing.T) {
+	s := newTestServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := s.WaitReady(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitReady without Run = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestShutdownHooks(t *testing.T) {
+	var order []string
+	errFirst, errThird := errors.New("first failed"), errors.New("third failed")
+	s := newTestServer(t,
+		WithShutdownHook(func() error {
+			order = append(order, "first")
+			return errFirst
+		}),
+		WithShutdownHook(func() error {
+			order = append(order, "second")
+			panic("second panicked")
+		}),
+		WithShutdownHook(func() error {
+			order = append(order, "third")
+			return errThird
+		}),
+	)
+	errc := startServer(t, s)
+
+	err := s.Stop()
+	if got := strings.Join(order, ","); got != "third,second,first" {
+		t.Errorf("hooks ran in order %s, want third,second,first", got)
+	}
+	if !errors.Is(err, errFirst) || !errors.Is(err, errThird) || !strings.Contains(err.Error(), "second panicked") {
+		t.Errorf("Stop = %v, want the errors of all three hooks", err)
+	}
+	if err := stopped(t, errc); err != nil {
+		t.Errorf("Run = %v, want nil after Stop", err)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "demo.sock")
+	// Leave a stale socket behind, as a crashed server would.
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	logs := new(logBuffer)
+	s := newTestServer(t, WithHost("unix://"+path), WithLogger(logs))
+	errc := startServer(t, s)
+	if !strings.Contains(logs.String(), "removing stale socket "+path) {
+		t.Errorf("log = %q, want a warning about the stale socket", logs)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Ping = %v, want nil", err)
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := stopped(t, errc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("socket file still there after Stop: %v", err)
+	}
+}
+
+func TestUnixSocketRefusesOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(path, []byte("keep me"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(t, WithHost("unix://"+path))
+	if err := s.Run(context.Background()); err == nil || !strings.Contains(err.Error(), "not a socket") {
+		t.Errorf("Run over a regular file = %v, want an error", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "keep me" {
+		t.Errorf("regular file at the socket path was changed: %q, %v", data, err)
+	}
+}
+
+func TestRequestContextDeadline(t *testing.T) {
+	s := newTestServer(t, WithTimeout(100*time.Millisecond))
+	type result struct {
+		hasDeadline bool
+		err         error
+		elapsed     time.Duration
+	}
+	results := make(chan result, 1)
+	if err := s.HandleFunc("/stall", func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, ok := r.Context().Deadline()
+		select {
+		case <-r.Context().Done():
+			results <- result{ok, r.Context().Err(), time.Since(start)}
+		case <-time.After(5 * time.Second):
+			results <- result{ok, nil, time.Since(start)}
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, s)
+
+	get(t, "http://"+addr+"/stall")
+	res := <-results
+	if !res.hasDeadline {
+		t.Error("request context has no deadline")
+	}
+	if !errors.Is(res.err, context.DeadlineExceeded) || res.elapsed > time.Second {
+		t.Errorf("handler saw %v after %s, want the deadline after the 100ms timeout", res.err, res.elapsed)
+	}
+}
+
+func TestWithRateLimit(t *testing.T) {
+	const burst = 3
+	s := newTestServer(t, WithRateLimit(10, burst))
+	addr := serve(t, s)
+
+	// Fire well over the burst at once, from several goroutines.
+	const n = 12
+	codes := make(chan int, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get("http://" + addr + "/healthz")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			codes <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	counts := make(map[int]int)
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusTooManyRequests] == 0 || counts[http.StatusOK] < burst || counts[http.StatusOK] > burst+2 {
+		t.Errorf("status counts %v, want about %d OK and the rest 429", counts, burst)
+	}
+
+	// At 10 requests a second, a token is back after 100ms.
+	time.Sleep(150 * time.Millisecond)
+	if code, _ := get(t, "http://"+addr+"/healthz"); code != http.StatusOK {
+		t.Errorf("GET after the rate settled = %d, want 200", code)
+	}
+}
+
+func TestWithRateLimitValidation(t *testing.T) {
+	if _, err := NewServer("127.0.0.1", 0, 0, WithRateLimit(-1, 1)); err == nil {
+		t.Error("negative rate accepted")
+	}
+	if _, err := NewServer("127.0.0.1", 0, 0, WithRateLimit(10, 0)); err == nil {
+		t.Error("zero burst accepted")
+	}
+}
+
+// noRedirects is a client that returns redirects instead of following them.
+var noRedirects = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+func TestServeTLSRedirect(t *testing.T) {
+	certFile, keyFile, _ := selfSignedCert(t)
+	s := newTestServer(t, WithTLS(certFile, keyFile))
+	addr := serve(t, s)
+	held, httpPort := holdPort(t)
+	held.Close()
+	if err := s.ServeTLSRedirect(httpPort); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := noRedirects.Get("http://127.0.0.1:" + strconv.Itoa(httpPort) + "/a/b?x=1&y=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if want := "https://" + addr + "/a/b?x=1&y=2"; resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != want {
+		t.Errorf("GET over HTTP = %d to %q, want 301 to %q", resp.StatusCode, resp.Header.Get("Location"), want)
+	}
+}
+
+func TestServeTLSRedirectWithoutTLS(t *testing.T) {
+	s := newTestServer(t)
+	serve(t, s)
+	if err := s.ServeTLSRedirect(0); err == nil {
+		t.Error("ServeTLSRedirect without TLS succeeded, want an error")
+	}
+}
+
+func TestRedirectToTLSHosts(t *testing.T) {
+	tests := []struct {
+		port int
+		host string
+		want string
+	}{
+		{8443, "example.com", "https://example.com:8443/a?b=1"},
+		{8443, "example.com:8080", "https://example.com:8443/a?b=1"},
+		{443, "example.com:80", "https://example.com/a?b=1"},
+		{443, "[::1]", "https://[::1]/a?b=1"},
+		{443, "[::1]:80", "https://[::1]/a?b=1"},
+		{8443, "[::1]", "https://[::1]:8443/a?b=1"},
+	}
+	for _, tt := range tests {
+		s := newTestServer(t, WithPort(tt.port), WithTLS("cert.pem", "key.pem"))
+		r := httptest.NewRequest("GET", "/a?b=1", nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		s.redirectToTLS(w, r)
+		if got := w.Header().Get("Location"); w.Code != http.StatusMovedPermanently || got != tt.want {
+			t.Errorf("redirect of %s to port %d = %d %q, want 301 %q", tt.host, tt.port, w.Code, got, tt.want)
+		}
+	}
+}
+
+func TestSeparateTimeouts(t *testing.T) {
+	s := newTestServer(t, WithTimeout(3*time.Second),
+		WithReadHeaderTimeout(500*time.Millisecond), WithIdleTimeout(time.Minute))
+	srv := s.httpServer()
+	tests := []struct {
+		field     string
+		got, want time.Duration
+	}{
+		{"ReadTimeout", srv.ReadTimeout, 3 * time.Second},
+		{"ReadHeaderTimeout", srv.ReadHeaderTimeout, 500 * time.Millisecond},
+		{"IdleTimeout", srv.IdleTimeout, time.Minute},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.field, tt.got, tt.want)
+		}
+	}
+
+	// Unset values fall back to the timeout.
+	srv = newTestServer(t, WithTimeout(3*time.Second)).httpServer()
+	if srv.ReadHeaderTimeout != 3*time.Second || srv.IdleTimeout != 3*time.Second {
+		t.Errorf("ReadHeaderTimeout, IdleTimeout = %s, %s, want the 3s timeout", srv.ReadHeaderTimeout, srv.IdleTimeout)
+	}
+}
+
+func TestWithRequestHook(t *testing.T) {
+	type observation struct {
+		path   string
+		status int
+		dur    time.Duration
+	}
+	seen := make(chan observation, 10)
+	hook := func(r *http.Request, status int, dur time.Duration) {
+		seen <- observation{r.URL.Path, status, dur}
+	}
+	s := newTestServer(t, WithRequestHook(hook))
+	if err := s.HandleFunc("/teapot", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+		w.WriteHeader(http.StatusTeapot)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The clone must keep the hook, so it is the one tested.
+	clone := s.Clone()
+	addr := serve(t, clone)
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/teapot", http.StatusTeapot},
+		{"/panic", http.StatusInternalServerError},
+		{"/healthz", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code, _ := get(t, "http://"+addr+tt.path); code != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.path, code, tt.status)
+		}
+		select {
+		case got := <-seen:
+			if got.path != tt.path || got.status != tt.status || got.dur <= 0 {
+				t.Errorf("hook saw %+v, want %s with status %d and a positive duration", got, tt.path, tt.status)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("hook not called for %s", tt.path)
+		}
+	}
+}
+
+// closes reports whether the response to a GET of url asks to close the
+// connection.
+func closes(t *testing.T, url string) bool {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.Close || resp.Header.Get("Connection") == "close"
+}
+
+func TestWithKeepAlives(t *testing.T) {
+	addr := serve(t, newTestServer(t))
+	if closes(t, "http://"+addr+"/healthz") {
+		t.Error("keep-alives are off by default")
+	}
+
+	addr = serve(t, newTestServer(t, WithKeepAlives(false)))
+	if !closes(t, "http://"+addr+"/healthz") {
+		t.Error("response has no Connection: close with keep-alives off")
+	}
+}
+
+func TestDisableKeepAlives(t *testing.T) {
+	s := newTestServer(t)
+	addr := serve(t, s)
+	if closes(t, "http://"+addr+"/healthz") {
+		t.Fatal("keep-alives are off before DisableKeepAlives")
+	}
+	s.DisableKeepAlives()
+	if !closes(t, "http://"+addr+"/healthz") {
+		t.Error("response has no Connection: close after DisableKeepAlives")
+	}
+}
+
+func TestShutdownLetsRequestsFinish(t *testing.T) {
+	s := newTestServer(t)
+	started := make(chan struct{})
+	if err := s.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	errc := startServer(t, s)
+	addr, _ := s.Addr()
+	result := startRequest(t, "http://"+addr+"/slow", started)
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("request in flight during Shutdown failed: %v", err)
+	}
+	if err := stopped(t, errc); err != nil {
+		t.Errorf("Run = %v, want nil after Shutdown", err)
+	}
+}
+
+func TestCloseCutsRequestsOff(t *testing.T) {
+	s := newTestServer(t, WithTimeout(0))
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	if err := s.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}); err != nil {
+		t.Fatal(err)
+	}
+	errc := startServer(t, s)
+	addr, _ := s.Addr()
+	result := startRequest(t, "http://"+addr+"/slow", started)
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-result:
+		if err == nil {
+			t.Error("request in flight during Close succeeded, want it cut off")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close left the request running")
+	}
+	if err := stopped(t, errc); err != nil {
+		t.Errorf("Run = %v, want nil after Close", err)
+	}
+}
+
+func TestShutdownContextExpires(t *testing.T) {
+	s := newTestServer(t, WithTimeout(0))
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	if err := s.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	}); err != nil {
+		t.Fatal(err)
+	}
+	startServer(t, s)
+	addr, _ := s.Addr()
+	startRequest(t, "http://"+addr+"/slow", started)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want a wrapped context.DeadlineExceeded", err)
+	}
+}
+
+func TestWithStaticDir(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "public")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello, file"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(base, "secret.txt")
+	if err := os.WriteFile(secret, []byte("top secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(dir, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(t, WithStaticDir("/assets/", dir))
+	addr := serve(t, s)
+
+	if code, body := get(t, "http://"+addr+"/assets/hello.txt"); code != http.StatusOK || body != "hello, file" {
+		t.Errorf("GET /assets/hello.txt = %d %q, want 200 with the file", code, body)
+	}
+	if code, _ := get(t, "http://"+addr+"/assets/missing.txt"); code != http.StatusNotFound {
+		t.Errorf("GET /assets/missing.txt = %d, want 404", code)
+	}
+	if code, body := get(t, "http://"+addr+"/assets/link.txt"); code == http.StatusOK || strings.Contains(body, "top secret") {
+		t.Errorf("GET /assets/link.txt = %d %q, want the symlink out of the directory refused", code, body)
+	}
+
+	// ".." is tried on the handler directly, since the mux would clean it.
+	r := httptest.NewRequest("GET", "/assets/hello.txt", nil)
+	r.URL.Path = "/assets/../secret.txt"
+	w := httptest.NewRecorder()
+	staticDir{prefix: "/assets/", dir: dir}.handler().ServeHTTP(w, r)
+	if w.Code == http.StatusOK || strings.Contains(w.Body.String(), "top secret") {
+		t.Errorf("GET /assets/../secret.txt = %d %q, want it kept inside the directory", w.Code, w.Body)
+	}
+}
+
+func TestWithStaticDirMissing(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	s := newTestServer(t, WithStaticDir("/assets", dir))
+	if err := s.Run(context.Background()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Run with a missing static directory = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestReadinessProbe(t *testing.T) {
+	s := newTestServer(t)
+	addr := serve(t, s)
+	url := "http://" + addr + "/readyz"
+
+	if code, body := get(t, url); code != http.StatusOK || body != "ok" {
+		t.Errorf("GET /readyz without a probe = %d %q, want 200 \"ok\"", code, body)
+	}
+	s.SetReadinessProbe(func() error { return errors.New("database unreachable") })
+	if code, body := get(t, url); code != http.StatusServiceUnavailable || !strings.Contains(body, "database unreachable") {
+		t.Errorf("GET /readyz with a failing probe = %d %q, want 503 with the error", code, body)
+	}
+	s.SetReadinessProbe(func() error { return nil })
+	if code, _ := get(t, url); code != http.StatusOK {
+		t.Errorf("GET /readyz with a passing probe = %d, want 200", code)
+	}
+	s.SetReadinessProbe(nil)
+	if code, _ := get(t, url); code != http.StatusOK {
+		t.Errorf("GET /readyz after clearing the probe = %d, want 200", code)
+	}
+}
+
+func TestReadinessProbeCalledPerRequest(t *testing.T) {
+	s := newTestServer(t)
+	addr := serve(t, s)
+	var calls atomic.Int32
+	s.SetReadinessProbe(func() error {
+		calls.Add(1)
+		return nil
+	})
+	for i := 0; i < 3; i++ {
+		get(t, "http://"+addr+"/readyz")
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("probe called %d times for 3 requests", n)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.HandleFunc("/id", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := RequestIDFromContext(r.Context())
+		if !ok {
+			http.Error(w, "no request ID", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(id))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, s)
+
+	fetch := func(sent string) (header, body string) {
+		req, _ := http.NewRequest("GET", "http://"+addr+"/id", nil)
+		if sent != "" {
+			req.Header.Set("X-Request-ID", sent)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.Header.Get("X-Request-ID"), string(b)
+	}
+
+	first, body := fetch("")
+	if first == "" || body != first {
+		t.Errorf("new request ID: header %q, context %q, want the same non-empty ID", first, body)
+	}
+	if second, _ := fetch(""); second == first {
+		t.Errorf("two requests both got ID %q", first)
+	}
+	if header, body := fetch("trace-123"); header != "trace-123" || body != "trace-123" {
+		t.Errorf("incoming ID trace-123 came back as header %q, context %q", header, body)
+	}
+
+	if _, ok := RequestIDFromContext(context.Background()); ok {
+		t.Error("RequestIDFromContext found an ID in a bare context")
+	}
+}
+
+// record serves r with the full handler of s, without a listener.
+func record(s *Server, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestWithCORS(t *testing.T) {
+	s := newTestServer(t, WithCORS([]string{"https://allowed.example"}))
+	if err := s.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/api", nil)
+	r.Header.Set("Origin", "https://allowed.example")
+	w := record(s, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://allowed.example" || w.Body.String() != "data" {
+		t.Errorf("allowed origin: Access-Control-Allow-Origin %q, body %q, want the origin echoed and the response", got, w.Body)
+	}
+
+	r = httptest.NewRequest("GET", "/api", nil)
+	r.Header.Set("Origin", "https://evil.example")
+	w = record(s, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" || w.Code != http.StatusOK || w.Body.String() != "data" {
+		t.Errorf("disallowed origin: Access-Control-Allow-Origin %q, %d %q, want no CORS header and the normal response", got, w.Code, w.Body)
+	}
+
+	r = httptest.NewRequest("OPTIONS", "/api", nil)
+	r.Header.Set("Origin", "https://allowed.example")
+	r.Header.Set("Access-Control-Request-Method", "PUT")
+	w = record(s, r)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") != "PUT" {
+		t.Errorf("preflight = %d with methods %q, want 204 allowing PUT", w.Code, w.Header().Get("Access-Control-Allow-Methods"))
+	}
+}
+
+func TestWithCORSWildcard(t *testing.T) {
+	s := newTestServer(t, WithCORS([]string{"*"}))
+	r := httptest.NewRequest("GET", "/healthz", nil)
+	r.Header.Set("Origin", "https://anyone.example")
+	if got := record(s, r).Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q, want *", got)
+	}
+}
+
+// ctxKey is the type of context keys set by the tests.
+type ctxKey string
+
+func TestWithBaseContext(t *testing.T) {
+	base, cancel := context.WithCancel(context.WithValue(context.Background(), ctxKey("db"), "handle"))
+	defer cancel()
+	s := newTestServer(t, WithBaseContext(base))
+	if err := s.HandleFunc("/db", func(w http.ResponseWriter, r *http.Request) {
+		db, _ := r.Context().Value(ctxKey("db")).(string)
+		w.Write([]byte(db))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	errc := startServer(t, s)
+	addr, _ := s.Addr()
+
+	if code, body := get(t, "http://"+addr+"/db"); code != http.StatusOK || body != "handle" {
+		t.Errorf("GET /db = %d %q, want the value from the base context", code, body)
+	}
+
+	cancel()
+	if err := stopped(t, errc); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run = %v after the base context was cancelled, want context.Canceled", err)
+	}
+	if s.IsRunning() {
+		t.Error("server still running after the base context was cancelled")
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	logs := &logBuffer{}
+	s := newTestServer(t, WithLogger(logs))
+	if err := s.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, s)
+
+	for i := 0; i < 2; i++ {
+		if code, _ := get(t, "http://"+addr+"/panic"); code != http.StatusInternalServerError {
+			t.Errorf("GET /panic = %d, want 500", code)
+		}
+	}
+	if code, _ := get(t, "http://"+addr+"/healthz"); code != http.StatusOK {
+		t.Errorf("GET /healthz after a panic = %d, want 200", code)
+	}
+	out := logs.String()
+	if !strings.Contains(out, "Server recovered from panic serving GET /panic: boom") || !strings.Contains(out, "goroutine ") {
+		t.Errorf("log = %q, want the panic and its stack trace", out)
+	}
+}
+
+func TestWithRecoveryOff(t *testing.T) {
+	s := newTestServer(t, WithRecovery(false))
+	if err := s.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if p := recover(); p != "boom" {
+			t.Errorf("recovered %v, want the handler's panic to carry on", p)
+		}
+	}()
+	record(s, httptest.NewRequest("GET", "/panic", nil))
+	t.Error("handler panic was recovered with recovery off")
+}
+
+func TestWithH2C(t *testing.T) {
+	s := newTestServer(t, WithH2C(true))
+	if err := s.HandleFunc("/proto", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, s)
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	defer transport.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: transport}).Get("http://" + addr + "/proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Errorf("prior-knowledge HTTP/2 client got %s, want HTTP/2.0", resp.Proto)
+	}
+
+	if code, body := get(t, "http://"+addr+"/proto"); code != http.StatusOK || body != "HTTP/1.1" {
+		t.Errorf("HTTP/1.1 client got %d %q, want HTTP/1.1 still served", code, body)
+	}
+}
+
+func TestWithH2CAndTLS(t *testing.T) {
+	certFile, keyFile, _ := selfSignedCert(t)
+	_, err := NewServer("127.0.0.1", 0, time.Second, WithH2C(true), WithTLS(certFile, keyFile))
+	if err == nil || !strings.Contains(err.Error(), "invalid h2c configuration") {
+		t.Errorf("NewServer with h2c and TLS = %v, want an invalid h2c configuration error", err)
+	}
+}
+
+func TestWithPortFallback(t *testing.T) {
+	_, port := holdPort(t)
+	logs := &logBuffer{}
+	s := newTestServer(t, WithPort(port), WithPortFallback(3), WithLogger(logs))
+	addr := serve(t, s)
+
+	_, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := strconv.Atoi(p)
+	if got <= port || got > port+3 {
+		t.Errorf("server listens on port %d, want one of the 3 after the busy %d", got, port)
+	}
+	if want := fmt.Sprintf("Server port %d is in use, falling back to %d", port, got); !strings.Contains(logs.String(), want) {
+		t.Errorf("log = %q, want %q", logs, want)
+	}
+}
+
+func TestWithPortFallbackExhausted(t *testing.T) {
+	_, port := holdPort(t)
+	next, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port+1)))
+	if err != nil {
+		t.Skipf("cannot hold port %d as well: %v", port+1, err)
+	}
+	defer next.Close()
+
+	s := newTestServer(t, WithPort(port), WithPortFallback(1))
+	err = s.Run(context.Background())
+	if !errors.Is(err, syscall.EADDRINUSE) || !strings.Contains(err.Error(), fmt.Sprintf("ports [%d %d] are all in use", port, port+1)) {
+		t.Errorf("Run with every port busy = %v, want both ports reported in use", err)
+	}
+}
+
+func TestWithPortFallbackOff(t *testing.T) {
+	_, port := holdPort(t)
+	s := newTestServer(t, WithPort(port))
+	if err := s.Run(context.Background()); !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("Run on a busy port without fallback = %v, want EADDRINUSE", err)
+	}
+}
+
+func TestWithPortFallbackValidation(t *testing.T) {
+	_, err := NewServer("127.0.0.1", 0, time.Second, WithPortFallback(-1))
+	if err == nil || !strings.Contains(err.Error(), "invalid port fallback -1") {
+		t.Errorf("NewServer with a negative port fallback = %v, want an error", err)
+	}
+}
+
+func TestWithGzip(t *testing.T) {
+	payload := strings.Repeat("hello gzip ", 200)
+	s := newTestServer(t, WithGzip(100))
+	if err := s.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, payload)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.HandleFunc("/small", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tiny"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/large", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := record(s, r)
+	if w.Code != http.StatusCreated || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("GET /large = %d with Content-Encoding %q, want 201 gzip", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	if w.Body.Len() >= len(payload) {
+		t.Errorf("compressed body is %d bytes, want less than %d", w.Body.Len(), len(payload))
+	}
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := io.ReadAll(zr); err != nil || string(body) != payload {
+		t.Errorf("decompressed body = %d bytes, %v, want the %d bytes written", len(body), err, len(payload))
+	}
+
+	r = httptest.NewRequest("GET", "/small", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w = record(s, r)
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != "tiny" {
+		t.Errorf("GET /small = %q with Content-Encoding %q, want it sent as is", w.Body, w.Header().Get("Content-Encoding"))
+	}
+
+	r = httptest.NewRequest("GET", "/large", nil)
+	w = record(s, r)
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != payload {
+		t.Errorf("GET /large without Accept-Encoding got Content-Encoding %q, want it sent as is", w.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestWithGzipLeavesRangesAlone(t *testing.T) {
+	payload := strings.Repeat("0123456789", 100)
+	s := newTestServer(t, WithGzip(10))
+	if err := s.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader(payload))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("GET", "/file", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Range", "bytes=0-499")
+	w := record(s, r)
+	if w.Code != http.StatusPartialContent || w.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("range request = %d with Content-Encoding %q, want an uncompressed 206", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 0-499/1000" || w.Body.String() != payload[:500] {
+		t.Errorf("range request got Content-Range %q and %d bytes, want the first 500 bytes", got, w.Body.Len())
+	}
+
+	r = httptest.NewRequest("GET", "/file", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w = record(s, r)
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Accept-Ranges") != "" {
+		t.Errorf("full response got Content-Encoding %q and Accept-Ranges %q, want gzip without byte ranges", w.Header().Get("Content-Encoding"), w.Header().Get("Accept-Ranges"))
+	}
+}
+
+func TestHandleFuncTimeout(t *testing.T) {
+	s := newTestServer(t, WithTimeout(100*time.Millisecond))
+	if err := s.HandleFuncTimeout("/slow", sleepHandler(300*time.Millisecond), 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.HandleFuncTimeout("/quick", sleepHandler(300*time.Millisecond), 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.HandleFunc("/plain", sleepHandler(300*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.HandleFuncTimeout("/deadline", func(w http.ResponseWriter, r *http.Request) {
+		deadline, _ := r.Context().Deadline()
+		fmt.Fprint(w, time.Until(deadline) > time.Second)
+	}, 2*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	addr := serve(t, s)
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/slow", http.StatusOK, "done"},
+		{"/quick", http.StatusServiceUnavailable, ""},
+		{"/plain", http.StatusServiceUnavailable, ""},
+		{"/deadline", http.StatusOK, "true"},
+	}
+	for _, tt := range tests {
+		if code, body := get(t, "http://"+addr+tt.path); code != tt.code || (code == http.StatusOK && body != tt.body) {
+			t.Errorf("GET %s = %d %q, want %d %q", tt.path, code, body, tt.code, tt.body)
+		}
+	}
+}
+
+func TestHandleFuncTimeoutWithoutServerTimeout(t *testing.T) {
+	s := newTestServer(t, WithTimeout(0))
+	if err := s.HandleFuncTimeout("/quick", sleepHandler(300*time.Millisecond), 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.HandleFunc("/plain", sleepHandler(50*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if w := record(s, httptest.NewRequest("GET", "/quick", nil)); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("GET /quick = %d, want 503 from its own limit", w.Code)
+	}
+	if w := record(s, httptest.NewRequest("GET", "/plain", nil)); w.Code != http.StatusOK || w.Body.String() != "done" {
+		t.Errorf("GET /plain = %d %q, want no limit", w.Code, w.Body)
+	}
+}
+
+func TestHandleFuncTimeoutValidation(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.HandleFuncTimeout("/zero", sleepHandler(0), 0); err == nil || !strings.Contains(err.Error(), "invalid timeout 0s") {
+		t.Errorf("HandleFuncTimeout with a zero timeout = %v, want an invalid timeout error", err)
+	}
+	if err := s.HandleFuncTimeout("/nil", nil, time.Second); err == nil {
+		t.Error("HandleFuncTimeout with a nil handler succeeded")
+	}
+}
+
+func TestWithMaxBodyBytes(t *testing.T) {
+	s := newTestServer(t, WithMaxBodyBytes(10))
+	if err := s.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write(body)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.HandleFunc("/drain", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body) // ignores the error
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		body    string
+		chunked bool
+		code    int
+	}{
+		{"under the limit", "/echo", "small", false, http.StatusOK},
+		{"at the limit", "/echo", "0123456789", false, http.StatusOK},
+		{"over the limit", "/echo", strings.Repeat("x", 100), false, http.StatusRequestEntityTooLarge},
+		{"over the limit without a length", "/echo", strings.Repeat("x", 100), true, http.StatusRequestEntityTooLarge},
+		{"error ignored by the handler", "/drain", strings.Repeat("x", 100), true, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			if tt.chunked {
+				r.ContentLength = -1
+			}
+			w := record(s, r)
+			if w.Code != tt.code {
+				t.Errorf("POST %s with %d bytes = %d, want %d", tt.path, len(tt.body), w.Code, tt.code)
+			}
+			if tt.code == http.StatusOK && w.Body.String() != tt.body {
+				t.Errorf("POST %s echoed %q, want %q", tt.path, w.Body, tt.body)
+			}
+		})
+	}
+}
+
+func TestWithMaxBodyBytesValidation(t *testing.T) {
+	_, err := NewServer("127.0.0.1", 0, time.Second, WithMaxBodyBytes(-1))
+	if err == nil || !strings.Contains(err.Error(), "invalid max body size -1") {
+		t.Errorf("NewServer with a negative max body size = %v, want an error", err)
+	}
+}
+
+func TestWithAccessLog(t *testing.T) {
+	tests := []struct {
+		format AccessLogFormat
+		want   string
+	}{
+		{CommonLogFormat, `^192\.0\.2\.1 - alice \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET /x\?a=1 HTTP/1\.1" 201 5 \S+\n$`},
+		{CombinedLogFormat, `^192\.0\.2\.1 - alice \[[^\]]+\] "GET /x\?a=1 HTTP/1\.1" 201 5 "https://ref\.example" "tester/1\.0" \S+\n$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format.String(), func(t *testing.T) {
+			logs := &logBuffer{}
+			s := newTestServer(t, WithAccessLog(tt.format), WithLogger(logs))
+			if err := s.HandleFunc("/x", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("hello"))
+			}); err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("GET", "/x?a=1", nil)
+			r.SetBasicAuth("alice", "secret")
+			r.Header.Set("Referer", "https://ref.example")
+			r.Header.Set("User-Agent", "tester/1.0")
+			record(s, r)
+			if got := logs.String(); !regexp.MustCompile(tt.want).MatchString(got) {
+				t.Errorf("access log = %q, want a line matching %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithAccessLogEmptyResponse(t *testing.T) {
+	logs := &logBuffer{}
+	s := newTestServer(t, WithAccessLog(CommonLogFormat), WithLogger(logs))
+	if err := s.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	record(s, httptest.NewRequest("GET", "/empty", nil))
+	if got := logs.String(); !strings.Contains(got, `- - [`) || !strings.Contains(got, `"GET /empty HTTP/1.1" 204 - `) {
+		t.Errorf("access log = %q, want no user and a size of -", got)
+	}
+}
+
+func TestWithAccessLogValidation(t *testing.T) {
+	_, err := NewServer("127.0.0.1", 0, time.Second, WithAccessLog(AccessLogFormat(7)))
+	if err == nil || !strings.Contains(err.Error(), "invalid access log format 7") {
+		t.Errorf("NewServer with an unknown access log format = %v, want an error", err)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// staticDir is a directory of files served under a URL prefix, see
+// WithStaticDir.
+type staticDir struct {
+	prefix string
+	dir    string
+}
+
+// pattern returns the mux pattern matching prefix and everything below it.
+func (d staticDir) pattern() string {
+	return strings.TrimSuffix(d.prefix, "/") + "/"
+}
+
+// handler serves the files of d.dir, with paths taken relative to the prefix.
+// Files are looked up through an os.Root, which confines them to the
+// directory: neither ".." nor a symlink pointing elsewhere can reach a file
+// outside it. Missing files get a 404.
+func (d staticDir) handler() http.Handler {
+	files := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		root, err := os.OpenRoot(d.dir)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer root.Close()
+		http.FileServer(http.FS(root.FS())).ServeHTTP(w, r)
+	})
+	return http.StripPrefix(strings.TrimSuffix(d.prefix, "/"), files)
+}
+
+// checkStaticDirs reports the first static directory that does not exist or
+// is not a directory, so Run fails up front rather than answering 404s.
+func (s *Server) checkStaticDirs() error {
+	for _, d := range s.static {
+		info, err := os.Stat(d.dir)
+		if err != nil {
+			return fmt.Errorf("static directory for %s: %w", d.prefix, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("static directory for %s: %s is not a directory", d.prefix, d.dir)
+		}
+	}
+	return nil
+}